@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historySearchLimit = 20
+	// messages waiting to be written to the transcript, more are dropped so a slow disk does not stall the room
+	transcriptQueueSize = 1024
+)
+
+type historyEntry struct {
+	At   time.Time `json:"at"`
+	From string    `json:"from"`
+	Body string    `json:"body"`
+}
+
+func (e historyEntry) message() Message {
+	return Message{from: e.From, body: e.Body}
+}
+
+// roomHistory keeps the most recent chat messages in a ring buffer and
+// optionally appends every message to the transcript file
+type roomHistory struct {
+	lock    sync.Mutex
+	entries []historyEntry
+	next    int
+	count   int
+	maxAge  time.Duration
+
+	transcript *transcript
+}
+
+func newHistory(size int, maxAge time.Duration) *roomHistory {
+	return &roomHistory{
+		entries: make([]historyEntry, size),
+		maxAge:  maxAge,
+	}
+}
+
+func (h *roomHistory) add(msg Message) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	e := historyEntry{At: time.Now(), From: msg.from, Body: msg.body}
+	h.push(e)
+	if h.transcript != nil && !h.transcript.append(e) {
+		log.Printf("transcript is behind, dropped message from %s", e.From)
+	}
+}
+
+func (h *roomHistory) push(e historyEntry) {
+	size := len(h.entries)
+	if size == 0 {
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % size
+	if h.count < size {
+		h.count++
+	}
+}
+
+// recent returns messages from the oldest to the newest skipping ones older than max age
+func (h *roomHistory) recent(now time.Time) []Message {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var msgs []Message
+	for _, e := range h.ordered() {
+		if h.maxAge > 0 && now.Sub(e.At) > h.maxAge {
+			continue
+		}
+		msgs = append(msgs, e.message())
+	}
+	return msgs
+}
+
+func (h *roomHistory) ordered() []historyEntry {
+	size := len(h.entries)
+	ordered := make([]historyEntry, 0, h.count)
+	for i := h.count; i > 0; i-- {
+		ordered = append(ordered, h.entries[(h.next-i+size)%size])
+	}
+	return ordered
+}
+
+// search looks for the term in the transcript when there is one, otherwise in the buffered messages,
+// only the most recent matches are returned
+func (h *roomHistory) search(term string) ([]historyEntry, error) {
+	h.lock.Lock()
+	t := h.transcript
+	entries := h.ordered()
+	h.lock.Unlock()
+
+	if t != nil {
+		var err error
+		entries, err = t.readAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transcript: %w", err)
+		}
+	}
+
+	term = strings.ToLower(term)
+	var matches []historyEntry
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Body), term) || strings.ToLower(e.From) == term {
+			matches = append(matches, e)
+		}
+	}
+
+	if len(matches) > historySearchLimit {
+		matches = matches[len(matches)-historySearchLimit:]
+	}
+	return matches, nil
+}
+
+// restore fills the buffer with the tail of the transcript and appends new messages to it
+func (h *roomHistory) restore(t *transcript) error {
+	entries, err := t.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to restore history: %w", err)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, e := range entries {
+		h.push(e)
+	}
+	h.transcript = t
+	return nil
+}
+
+// transcript is an append-only file with one json encoded message per line,
+// messages are queued and written by a goroutine of its own
+type transcript struct {
+	path  string
+	f     *os.File
+	queue chan historyEntry
+	done  chan struct{}
+
+	lock    sync.Mutex
+	flushed *sync.Cond
+	pending int
+	closed  bool
+}
+
+func openTranscript(path string) (*transcript, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript %s: %w", path, err)
+	}
+
+	t := &transcript{
+		path:  path,
+		f:     f,
+		queue: make(chan historyEntry, transcriptQueueSize),
+		done:  make(chan struct{}),
+	}
+	t.flushed = sync.NewCond(&t.lock)
+	err = t.terminateTornWrite()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open transcript %s: %w", path, err)
+	}
+	go t.writeQueued()
+	return t, nil
+}
+
+// terminateTornWrite makes sure new entries do not get glued to a partial line left by a crash
+func (t *transcript) terminateTornWrite() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, info.Size()-1)
+	if err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = t.f.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// append queues the entry, it returns false when the queue is full or the transcript is closed
+func (t *transcript) append(e historyEntry) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return false
+	}
+	select {
+	case t.queue <- e:
+		t.pending++
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *transcript) writeQueued() {
+	defer close(t.done)
+	for e := range t.queue {
+		if err := t.write(e); err != nil {
+			log.Printf("failed to write transcript: %v", err)
+		}
+
+		t.lock.Lock()
+		t.pending--
+		if t.pending == 0 {
+			t.flushed.Broadcast()
+		}
+		t.lock.Unlock()
+	}
+}
+
+// flush waits until queued entries are written
+func (t *transcript) flush() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for t.pending > 0 {
+		t.flushed.Wait()
+	}
+}
+
+func (t *transcript) write(e historyEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = t.f.Write(append(line, '\n'))
+	return err
+}
+
+func (t *transcript) readAll() ([]historyEntry, error) {
+	t.flush()
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			// torn write at the end of the file after a crash
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// Close writes queued entries and closes the file
+func (t *transcript) Close() error {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.queue)
+	t.lock.Unlock()
+
+	<-t.done
+	return t.f.Close()
+}
+
+func historyCommand(r *ChatRoom, m *Member, term string) error {
+	if term == "" {
+		m.notify("* usage: /history <term>")
+		return nil
+	}
+
+	matches, err := r.history.search(term)
+	if err != nil {
+		return err
+	}
+
+	if len(matches) == 0 {
+		m.notify(fmt.Sprintf("* no messages matching %q", term))
+		return nil
+	}
+	for _, e := range matches {
+		m.notify(fmt.Sprintf("* %s [%s] %s", e.At.Format("2006-01-02 15:04:05"), e.From, e.Body))
+	}
+	return nil
+}
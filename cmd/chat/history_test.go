@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryKeepsMostRecentMessages(t *testing.T) {
+	h := newHistory(3, 0)
+	for i := 0; i < 5; i++ {
+		h.add(Message{from: "max", body: fmt.Sprintf("%d", i)})
+	}
+
+	recent := h.recent(time.Now())
+	require.Len(t, recent, 3)
+	require.Equal(t, "2", recent[0].body)
+	require.Equal(t, "3", recent[1].body)
+	require.Equal(t, "4", recent[2].body)
+}
+
+func TestHistorySkipsExpiredMessages(t *testing.T) {
+	h := newHistory(3, time.Minute)
+	h.add(Message{from: "max", body: "old"})
+	h.add(Message{from: "max", body: "new"})
+
+	require.Len(t, h.recent(time.Now()), 2)
+	require.Empty(t, h.recent(time.Now().Add(2*time.Minute)))
+}
+
+func TestHistoryRestoredFromTranscript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.log")
+
+	tr, err := openTranscript(path)
+	require.NoError(t, err)
+	h := newHistory(2, 0)
+	require.NoError(t, h.restore(tr))
+	h.add(Message{from: "max", body: "first"})
+	h.add(Message{from: "max", body: "second"})
+	h.add(Message{from: "charlie", body: "third"})
+	tr.flush()
+	_, err = tr.f.Write([]byte(`{"at":"2022-`)) // crashed in the middle of a write
+	require.NoError(t, err)
+	require.NoError(t, tr.Close())
+
+	// restart
+	tr, err = openTranscript(path)
+	require.NoError(t, err)
+	defer tr.Close()
+	h = newHistory(2, 0)
+	require.NoError(t, h.restore(tr))
+
+	recent := h.recent(time.Now())
+	require.Len(t, recent, 2)
+	require.Equal(t, Message{from: "max", body: "second"}, recent[0])
+	require.Equal(t, Message{from: "charlie", body: "third"}, recent[1])
+
+	matches, err := h.search("FIRST")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, "first", matches[0].Body)
+
+	h.add(Message{from: "max", body: "fourth"})
+	entries, err := tr.readAll()
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+}
+
+func TestHistoryReplayedOnJoin(t *testing.T) {
+	r := newRoom()
+	r.history = newHistory(10, 0)
+
+	max, maxReader := join(t, r, "max")
+	defer max.Close()
+
+	_, err := max.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	_, err = max.Write([]byte("anyone there?\n"))
+	require.NoError(t, err)
+	_, err = max.Write([]byte("/history hello\n"))
+	require.NoError(t, err)
+	require.Contains(t, readLine(t, maxReader), "[max] hello")
+
+	charlie, charlieReader := join(t, r, "charlie")
+	defer charlie.Close()
+
+	require.Equal(t, "[max] hello", readLine(t, charlieReader))
+	require.Equal(t, "[max] anyone there?", readLine(t, charlieReader))
+}
+
+func TestTranscriptDoesNotBlockWhenBehind(t *testing.T) {
+	// no writer drains the queue, as if the disk stalled
+	tr := &transcript{queue: make(chan historyEntry, 1)}
+	tr.flushed = sync.NewCond(&tr.lock)
+
+	require.True(t, tr.append(historyEntry{From: "max", Body: "first"}))
+	require.False(t, tr.append(historyEntry{From: "max", Body: "second"}))
+}
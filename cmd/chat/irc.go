@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	ircServerName = "budgetchat"
+	ircChannel    = "#budgetchat"
+)
+
+// IRC numeric replies used by the bridge, see RFC 2812
+const (
+	rplWelcome          = "001"
+	rplNamReply         = "353"
+	rplEndOfNames       = "366"
+	errNoSuchChannel    = "403"
+	errUnknownCommand   = "421"
+	errNoMotd           = "422"
+	errErroneusNick     = "432"
+	errNicknameInUse    = "433"
+	errNotRegistered    = "451"
+	errNeedMoreParams   = "461"
+	errYoureBannedCreep = "465"
+)
+
+var (
+	errIRCQuit = errors.New("irc client quit")
+)
+
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+// parseIRCLine splits `[:prefix] COMMAND param ... [:trailing param]`
+func parseIRCLine(line string) ircMessage {
+	var msg ircMessage
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, ":") {
+		msg.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			msg.params = append(msg.params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param == "" {
+			continue
+		}
+		if msg.command == "" {
+			msg.command = strings.ToUpper(param)
+		} else {
+			msg.params = append(msg.params, param)
+		}
+	}
+	return msg
+}
+
+func (m ircMessage) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// IRCMemberNet maps IRC commands of a client joined to the single channel onto the room
+type IRCMemberNet struct {
+	*MemberNet
+	room *ChatRoom
+	nick string
+}
+
+func newIRCMemberNetwork(c net.Conn, r *ChatRoom) *IRCMemberNet {
+	return &IRCMemberNet{
+		MemberNet: newMemberNetwork(c),
+		room:      r,
+		nick:      "*",
+	}
+}
+
+func ircUserPrefix(nick string) string {
+	return fmt.Sprintf("%s!%s@%s", nick, nick, ircServerName)
+}
+
+func (n *IRCMemberNet) send(prefix string, command string, params ...string) error {
+	line := strings.Builder{}
+	if prefix != "" {
+		line.WriteString(":" + prefix + " ")
+	}
+	line.WriteString(command)
+	for i, p := range params {
+		if i == len(params)-1 && (p == "" || strings.Contains(p, " ") || strings.HasPrefix(p, ":")) {
+			line.WriteString(" :" + p)
+		} else {
+			line.WriteString(" " + p)
+		}
+	}
+	line.WriteString("\r\n")
+	return n.MemberNet.WriteText(line.String())
+}
+
+func (n *IRCMemberNet) reply(numeric string, params ...string) error {
+	return n.send(ircServerName, numeric, append([]string{n.nick}, params...)...)
+}
+
+// register completes NICK/USER registration and waits for the client to join the channel,
+// the nick is reserved in the room once registration completes and released when joining fails
+func (n *IRCMemberNet) register() (member *Member, err error) {
+	var (
+		registered bool
+		user       string
+	)
+	defer func() {
+		if err != nil && member != nil {
+			n.room.leave(member, "")
+			member = nil
+		}
+	}()
+
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return member, fmt.Errorf("failed to read registration: %w", err)
+		}
+
+		msg := parseIRCLine(line)
+		switch msg.command {
+		case "PING":
+			n.send(ircServerName, "PONG", ircServerName, msg.param(0))
+		case "CAP":
+			if msg.param(0) == "LS" {
+				n.send(ircServerName, "CAP", "*", "LS", "")
+			}
+		case "NICK":
+			if registered {
+				n.send(ircServerName, "NOTICE", n.nick, "nick changes are not supported")
+				continue
+			}
+			n.nick = msg.param(0)
+		case "USER":
+			user = msg.param(0)
+		case "JOIN":
+			if !registered {
+				n.reply(errNotRegistered, "You have not registered")
+				continue
+			}
+			if msg.param(0) != ircChannel {
+				n.reply(errNoSuchChannel, msg.param(0), fmt.Sprintf("Only %s is available", ircChannel))
+				continue
+			}
+			return member, nil
+		case "QUIT":
+			return member, errIRCQuit
+		default:
+			if !registered {
+				n.reply(errNotRegistered, "You have not registered")
+			}
+		}
+
+		if registered || n.nick == "*" || user == "" {
+			continue
+		}
+
+		member, err = n.room.reserveMember(n.nick, n)
+		switch {
+		case errors.Is(err, errInvalidUsername):
+			n.reply(errErroneusNick, n.nick, err.Error())
+			n.nick = "*"
+		case errors.Is(err, errUniqueUsername):
+			n.reply(errNicknameInUse, n.nick, "Nickname is already in use")
+			n.nick = "*"
+		case errors.Is(err, errBanned):
+			n.reply(errYoureBannedCreep, err.Error())
+			return nil, err
+		case err != nil:
+			return nil, err
+		default:
+			registered = true
+			n.reply(rplWelcome, fmt.Sprintf("Welcome to budgetchat %s, join %s", ircUserPrefix(n.nick), ircChannel))
+			n.reply(errNoMotd, "MOTD File is missing")
+		}
+	}
+}
+
+// readLine waits for the next line at most the idle timeout of the room, the deadline moves with every line so active clients stay
+func (n *IRCMemberNet) readLine() (string, error) {
+	n.conn.SetReadDeadline(time.Now().Add(n.room.idleTimeout))
+	return n.MemberNet.ReadLine()
+}
+
+// ReadLine returns channel messages, other commands are answered on the spot
+func (n *IRCMemberNet) ReadLine() (string, error) {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return "", err
+		}
+
+		msg := parseIRCLine(line)
+		switch msg.command {
+		case "PRIVMSG", "NOTICE":
+			if len(msg.params) < 2 {
+				n.reply(errNeedMoreParams, msg.command, "Not enough parameters")
+				continue
+			}
+			if msg.param(0) != ircChannel {
+				n.send(ircServerName, "NOTICE", n.nick, "only messages to "+ircChannel+" are supported")
+				continue
+			}
+			return msg.param(1), nil
+		case "PING":
+			n.send(ircServerName, "PONG", ircServerName, msg.param(0))
+		case "NAMES":
+			n.names(n.room.getMembers())
+		case "PART", "QUIT":
+			return "", io.EOF
+		case "JOIN", "MODE", "WHO", "USER", "CAP", "PONG":
+			// already joined, nothing to tell
+		case "NICK":
+			n.send(ircServerName, "NOTICE", n.nick, "nick changes are not supported")
+		default:
+			n.reply(errUnknownCommand, msg.command, "Unknown command")
+		}
+	}
+}
+
+func (n *IRCMemberNet) names(members []string) error {
+	err := n.reply(rplNamReply, "=", ircChannel, strings.Join(members, " "))
+	if err != nil {
+		return err
+	}
+	return n.reply(rplEndOfNames, ircChannel, "End of /NAMES list")
+}
+
+// WriteText delivers budgetchat notices, like the kick reason, as IRC notices
+func (n *IRCMemberNet) WriteText(txt string) error {
+	for _, line := range strings.Split(normalizeReadLine(txt), "\n") {
+		err := n.send(ircServerName, "NOTICE", n.nick, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *IRCMemberNet) WriteMessage(msg Message) error {
+	switch msg.kind {
+	case chatMessage:
+		return n.send(ircUserPrefix(msg.from), "PRIVMSG", ircChannel, msg.body)
+	case joinedMessage:
+		return n.send(ircUserPrefix(msg.from), "JOIN", ircChannel)
+	case leftMessage:
+		return n.send(ircUserPrefix(msg.from), "PART", ircChannel)
+	case membersMessage:
+		err := n.send(ircUserPrefix(n.nick), "JOIN", ircChannel)
+		if err != nil {
+			return err
+		}
+		return n.names(append([]string{n.nick}, msg.members...))
+	default:
+		return n.WriteText(msg.body)
+	}
+}
+
+func (r *ChatRoom) serveIRC(c net.Conn) {
+	n := newIRCMemberNetwork(c, r)
+	defer func() {
+		fmt.Print("Closing irc connection on server\n")
+		n.Close()
+	}()
+
+	if r.mod.isBannedIP(n.RemoteAddr()) {
+		n.send("", "ERROR", errBanned.Error())
+		return
+	}
+
+	member, err := n.register()
+	if err != nil {
+		log.Printf("irc registration failed: %v", err)
+		return
+	}
+	r.runMember(member)
+}
+
+func startIRCServer(addr string, r *ChatRoom) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("irc listener failed: %v", err)
+		return
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go r.serveIRC(conn)
+	}
+}
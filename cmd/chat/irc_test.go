@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func joinIRC(t *testing.T, r *ChatRoom, nick string) (net.Conn, *bufio.Reader) {
+	server, client := net.Pipe()
+	go r.serveIRC(server)
+
+	reader := bufio.NewReader(client)
+	writeIRC(t, client, "NICK "+nick)
+	writeIRC(t, client, "USER "+nick+" 0 * :"+nick)
+	require.Equal(t, ":budgetchat 001 "+nick+" :Welcome to budgetchat "+nick+"!"+nick+"@budgetchat, join #budgetchat", readIRC(t, reader))
+	require.Equal(t, ":budgetchat 422 "+nick+" :MOTD File is missing", readIRC(t, reader))
+
+	writeIRC(t, client, "JOIN #budgetchat")
+	require.Equal(t, ":"+nick+"!"+nick+"@budgetchat JOIN #budgetchat", readIRC(t, reader))
+	return client, reader
+}
+
+func writeIRC(t *testing.T, conn net.Conn, line string) {
+	_, err := conn.Write([]byte(line + "\r\n"))
+	require.NoError(t, err)
+}
+
+func readIRC(t *testing.T, r *bufio.Reader) string {
+	return strings.TrimSuffix(readLine(t, r), "\r")
+}
+
+func TestParseIRCLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		exp  ircMessage
+	}{
+		{
+			line: "NICK max",
+			exp:  ircMessage{command: "NICK", params: []string{"max"}},
+		},
+		{
+			line: "privmsg #budgetchat :hello there :)\r\n",
+			exp:  ircMessage{command: "PRIVMSG", params: []string{"#budgetchat", "hello there :)"}},
+		},
+		{
+			line: ":max!max@host PART  #budgetchat",
+			exp:  ircMessage{prefix: "max!max@host", command: "PART", params: []string{"#budgetchat"}},
+		},
+		{
+			line: "USER max 0 * :Max M",
+			exp:  ircMessage{command: "USER", params: []string{"max", "0", "*", "Max M"}},
+		},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			require.Equal(t, tc.exp, parseIRCLine(tc.line))
+		})
+	}
+}
+
+func TestIRCSharesRoomWithBudgetchat(t *testing.T) {
+	r := newRoom()
+
+	max, maxReader := join(t, r, "max")
+	defer max.Close()
+
+	irc, ircReader := joinIRC(t, r, "charlie")
+	defer irc.Close()
+	require.Equal(t, ":budgetchat 353 charlie = #budgetchat :charlie max", readIRC(t, ircReader))
+	require.Equal(t, ":budgetchat 366 charlie #budgetchat :End of /NAMES list", readIRC(t, ircReader))
+	require.Equal(t, "* charlie has entered the room", readLine(t, maxReader))
+
+	writeIRC(t, irc, "PRIVMSG #budgetchat :hi from irc")
+	require.Equal(t, "[charlie] hi from irc", readLine(t, maxReader))
+
+	_, err := max.Write([]byte("hi from nc\n"))
+	require.NoError(t, err)
+	require.Equal(t, ":max!max@budgetchat PRIVMSG #budgetchat :hi from nc", readIRC(t, ircReader))
+
+	writeIRC(t, irc, "PING :123")
+	require.Equal(t, ":budgetchat PONG budgetchat 123", readIRC(t, ircReader))
+
+	bob, _ := join(t, r, "bob")
+	defer bob.Close()
+	require.Equal(t, ":bob!bob@budgetchat JOIN #budgetchat", readIRC(t, ircReader))
+	require.Equal(t, "* bob has entered the room", readLine(t, maxReader))
+
+	writeIRC(t, irc, "NAMES #budgetchat")
+	names := readIRC(t, ircReader)
+	require.True(t, strings.HasPrefix(names, ":budgetchat 353 charlie = #budgetchat :"), names)
+	require.ElementsMatch(t, []string{"max", "charlie", "bob"}, strings.Fields(strings.SplitN(names, ":", 3)[2]))
+	require.Equal(t, ":budgetchat 366 charlie #budgetchat :End of /NAMES list", readIRC(t, ircReader))
+
+	bob.Close()
+	require.Equal(t, ":bob!bob@budgetchat PART #budgetchat", readIRC(t, ircReader))
+	require.Equal(t, "* bob has left the room", readLine(t, maxReader))
+
+	writeIRC(t, irc, "QUIT :bye")
+	require.Equal(t, "* charlie has left the room", readLine(t, maxReader))
+}
+
+func TestIRCNickInUse(t *testing.T) {
+	r := newRoom()
+
+	max, _ := join(t, r, "max")
+	defer max.Close()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go r.serveIRC(server)
+	reader := bufio.NewReader(client)
+
+	writeIRC(t, client, "NICK max")
+	writeIRC(t, client, "USER max 0 * :max")
+	require.Equal(t, ":budgetchat 433 max max :Nickname is already in use", readIRC(t, reader))
+
+	writeIRC(t, client, "NICK not-valid")
+	require.Equal(t, ":budgetchat 432 not-valid not-valid :"+errInvalidUsername.Error(), readIRC(t, reader))
+
+	writeIRC(t, client, "NICK maks")
+	require.Contains(t, readIRC(t, reader), ":budgetchat 001 maks")
+}
+
+func TestIRCNickReleasedWhenJoinFails(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		leave func(conn net.Conn)
+	}{
+		{"quit", func(conn net.Conn) { writeIRC(t, conn, "QUIT :bye") }},
+		{"disconnect", func(conn net.Conn) { conn.Close() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRoom()
+			server, client := net.Pipe()
+			defer client.Close()
+			done := make(chan struct{})
+			go func() {
+				r.serveIRC(server)
+				close(done)
+			}()
+
+			reader := bufio.NewReader(client)
+			writeIRC(t, client, "NICK max")
+			writeIRC(t, client, "USER max 0 * :max")
+			require.Contains(t, readIRC(t, reader), " 001 max ")
+			require.Contains(t, readIRC(t, reader), " 422 max ")
+			require.True(t, r.memberNameTaken("max"))
+
+			tc.leave(client)
+			<-done
+			require.False(t, r.memberNameTaken("max"))
+		})
+	}
+}
+
+func TestIRCIdleTimeout(t *testing.T) {
+	r := newRoom()
+	r.idleTimeout = 300 * time.Millisecond
+	client, reader := joinIRC(t, r, "max")
+	defer client.Close()
+	closed := make(chan struct{})
+	go func() {
+		for range readLines(reader) {
+		}
+		close(closed)
+	}()
+
+	// active clients outlive the timeout
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		writeIRC(t, client, "PING keepalive")
+	}
+	require.True(t, r.memberNameTaken("max"))
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "idle client was not disconnected")
+	}
+	require.Eventually(t, func() bool { return !r.memberNameTaken("max") }, 2*time.Second, 10*time.Millisecond)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -16,16 +17,91 @@ import (
 
 const (
 	serverPort = 8888
+
+	// time given to a slow consumer to receive the disconnect notice
+	slowConsumerNoticeTimeout = time.Second
+	slowConsumerNotice        = "* you are too slow to keep up with the room, disconnecting\n"
+
+	// irc and websocket members are disconnected once they send nothing for that long
+	idleTimeout = 120 * time.Second
 )
 
 var (
-	errInvalidUsername    = errors.New("username should be between 1 and 16 alphanumeric characters")
-	errUniqueUsername     = errors.New("username already taken")
-	errChatMessageTooLong = errors.New("chat message too long, 1100 characters allowed")
-	isAlphanumeric        = regexp.MustCompile(`^[a-zA-Z0-9]{1,16}$`).MatchString
+	errInvalidUsername      = errors.New("username should be between 1 and 16 alphanumeric characters")
+	errUniqueUsername       = errors.New("username already taken")
+	errChatMessageTooLong   = errors.New("chat message too long, 1100 characters allowed")
+	errChatMessageLineBreak = errors.New("chat message has a line break")
+	isAlphanumeric          = regexp.MustCompile(`^[a-zA-Z0-9]{1,16}$`).MatchString
+
+	memberQueueSize = 64
+	slowConsumer    = dropOldest
+	historySize     = 0
+	historyMaxAge   = time.Duration(0)
+	transcriptPath  = ""
+	rateLimit       = 0.0
+	rateBurst       = 5
+	banListPath     = ""
+	operatorList    = ""
+	profanityPath   = ""
+	wsAddr          = ""
+	ircAddr         = ""
+
+	commands = map[string]command{
+		"history": historyCommand,
+		"kick":    kickCommand,
+		"mute":    muteCommand,
+	}
 )
 
+// command is run for messages starting with `/name`, args are the rest of the message
+type command func(r *ChatRoom, m *Member, args string) error
+
+// slowConsumerPolicy decides what happens to a member whose outbound queue is full
+type slowConsumerPolicy int
+
+const (
+	dropOldest slowConsumerPolicy = iota
+	disconnectSlow
+)
+
+func (p slowConsumerPolicy) String() string {
+	switch p {
+	case dropOldest:
+		return "drop-oldest"
+	case disconnectSlow:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("slowConsumerPolicy(%d)", int(p))
+	}
+}
+
+func (p *slowConsumerPolicy) Set(v string) error {
+	switch v {
+	case "drop-oldest":
+		*p = dropOldest
+	case "disconnect":
+		*p = disconnectSlow
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q (drop-oldest, disconnect)", v)
+	}
+	return nil
+}
+
 func main() {
+	flag.IntVar(&memberQueueSize, "queue-size", memberQueueSize, "outbound messages buffered per member")
+	flag.Var(&slowConsumer, "slow-consumer", "policy for members with a full queue: drop-oldest or disconnect")
+	flag.IntVar(&historySize, "history-size", historySize, "recent messages replayed to joining members")
+	flag.DurationVar(&historyMaxAge, "history-max-age", historyMaxAge, "skip replaying messages older than that, 0 means no limit")
+	flag.StringVar(&transcriptPath, "transcript", transcriptPath, "append-only transcript file used to restore and search history")
+	flag.Float64Var(&rateLimit, "rate-limit", rateLimit, "messages per second allowed per member, 0 means no limit")
+	flag.IntVar(&rateBurst, "rate-burst", rateBurst, "messages a member can send at once before being rate limited")
+	flag.StringVar(&banListPath, "bans", banListPath, "file with a banned username or ip address per line")
+	flag.StringVar(&operatorList, "operators", operatorList, "comma separated operators allowed to /kick and /mute, as name@ip")
+	flag.StringVar(&profanityPath, "profanity", profanityPath, "file with a word per line masked in messages")
+	flag.StringVar(&wsAddr, "ws-addr", wsAddr, "address of the WebSocket listener sharing the room, empty disables it")
+	flag.StringVar(&ircAddr, "irc-addr", ircAddr, "address of the IRC listener sharing the room as "+ircChannel+", empty disables it")
+	flag.Parse()
+
 	startServer()
 }
 
@@ -33,56 +109,118 @@ type Message struct {
 	from        string
 	body        string
 	excludeFrom bool
+	kind        messageKind
+	members     []string
+}
+
+// messageKind lets connections other than budgetchat lines present room events in their own way
+type messageKind int
+
+const (
+	chatMessage messageKind = iota
+	noticeMessage
+	joinedMessage
+	leftMessage
+	membersMessage
+)
+
+// MessageWriter is implemented by member connections which render room messages themselves
+type MessageWriter interface {
+	WriteMessage(msg Message) error
+}
+
+// MemberConn is how a member is connected to the room, plain TCP lines or WebSocket frames
+type MemberConn interface {
+	ReadLine() (string, error)
+	WriteText(txt string) error
+	SetWriteDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+	Close() error
 }
 
 type MemberNet struct {
-	conn net.Conn
-	w    *bufio.Writer
-	r    *bufio.Reader
+	conn  net.Conn
+	w     *bufio.Writer
+	r     *bufio.Reader
+	wlock sync.Mutex
 }
 
+// memberState is guarded by the room lock, members move from joining through active to leaving and never back
+type memberState int
+
+const (
+	joining memberState = iota
+	active
+	leaving
+)
+
 type Member struct {
 	name  string
 	input chan Message
-	conn  *MemberNet
+	conn  MemberConn
+	state memberState
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	operator bool
+	limiter  *tokenBucket
 }
 
 type ChatRoom struct {
-	members map[string]*Member
-	lock    *sync.RWMutex
+	members   map[string]*Member
+	lock      *sync.RWMutex
+	queueSize int
+	policy    slowConsumerPolicy
+	history   *roomHistory
+	mod       *moderation
+	filters   []messageFilter
+	// read deadline of members connected over irc and websocket
+	idleTimeout time.Duration
 }
 
-func (r *ChatRoom) initMember(c net.Conn) (*Member, error) {
-	mnet := newMemberNetwork(c)
-	_, err := mnet.w.WriteString("Welcome to budgetchat! What shall I call you?\n")
+func (r *ChatRoom) initMember(mconn MemberConn) (*Member, error) {
+	err := mconn.WriteText("Welcome to budgetchat! What shall I call you?\n")
 	if err != nil {
 		return nil, fmt.Errorf("failed to write welcome message: %w", err)
 	}
-	err = mnet.w.Flush()
-	if err != nil {
-		return nil, fmt.Errorf("failed to flush write: %w", err)
-	}
 
-	username, err := mnet.r.ReadString('\n')
+	username, err := mconn.ReadLine()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read username: %w", err)
 	}
-	username = normalizeReadLine(username)
 
+	return r.reserveMember(username, mconn)
+}
+
+// reserveMember validates the name and reserves it for the new member
+func (r *ChatRoom) reserveMember(username string, mconn MemberConn) (*Member, error) {
 	if !isAlphanumeric(username) {
 		return nil, errInvalidUsername
 	}
 
-	if r.memberNameTaken(username) {
-		return nil, errUniqueUsername
+	if r.mod.isBannedName(username) {
+		return nil, errBanned
 	}
 
-	u := &Member{}
-	u.input = make(chan Message, 1)
-	u.name = username
-	u.conn = mnet
+	m := newMember(username, mconn, r.queueSize)
+	m.operator = r.mod.isOperator(username, mconn.RemoteAddr())
+	m.limiter = r.mod.newLimiter()
+
+	err := r.reserve(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-	return u, nil
+func newMember(name string, mconn MemberConn, queueSize int) *Member {
+	return &Member{
+		name:  name,
+		input: make(chan Message, queueSize),
+		conn:  mconn,
+		done:  make(chan struct{}),
+	}
 }
 
 func normalizeReadLine(txt string) string {
@@ -97,6 +235,43 @@ func newMemberNetwork(c net.Conn) *MemberNet {
 	}
 }
 
+func (n *MemberNet) ReadLine() (string, error) {
+	txt, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return normalizeReadLine(txt), nil
+}
+
+func (n *MemberNet) WriteText(txt string) error {
+	n.wlock.Lock()
+	defer n.wlock.Unlock()
+
+	_, err := n.w.WriteString(txt)
+	if err != nil {
+		return fmt.Errorf("cannot send txt: %w", err)
+	}
+
+	err = n.w.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to flush write: %w", err)
+	}
+
+	return nil
+}
+
+func (n *MemberNet) SetWriteDeadline(t time.Time) error {
+	return n.conn.SetWriteDeadline(t)
+}
+
+func (n *MemberNet) RemoteAddr() net.Addr {
+	return n.conn.RemoteAddr()
+}
+
+func (n *MemberNet) Close() error {
+	return n.conn.Close()
+}
+
 func (r *ChatRoom) memberNameTaken(name string) bool {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
@@ -104,49 +279,92 @@ func (r *ChatRoom) memberNameTaken(name string) bool {
 	return ok
 }
 
-func (r *ChatRoom) registerMember(m *Member) {
+// reserve claims the member name atomically, member stays invisible to the room until registered
+func (r *ChatRoom) reserve(m *Member) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+
+	if _, ok := r.members[m.name]; ok {
+		return errUniqueUsername
+	}
+	m.state = joining
 	r.members[m.name] = m
+	return nil
 }
 
-func (r *ChatRoom) unregisterMember(u *Member) {
+// registerMember activates the member and returns recent messages, every later message is delivered through member queue
+func (r *ChatRoom) registerMember(m *Member) []Message {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	m.state = active
+	r.members[m.name] = m
+	return r.history.recent(time.Now())
+}
+
+// leave moves the member to leaving state exactly once, closing the connection stops both member
+// reader and writer, only members which were active are announced as leaving
+func (r *ChatRoom) leave(m *Member, notice string) {
+	r.lock.Lock()
+	prev := m.state
+	if prev != leaving {
+		m.state = leaving
+		if r.members[m.name] == m {
+			delete(r.members, m.name)
+		}
+	}
+	r.lock.Unlock()
 
-	delete(r.members, u.name)
+	if prev == leaving {
+		return
+	}
+
+	m.disconnect(notice)
+	if prev == active {
+		r.publish(Message{
+			from:        m.name,
+			body:        fmt.Sprintf("* %s has left the room", m.name),
+			excludeFrom: true,
+			kind:        leftMessage,
+		})
+	}
 }
 
-func (r *ChatRoom) onRegisteredUser(u *Member) error {
+func (r *ChatRoom) onRegisteredUser(u *Member, backlog []Message) error {
 	msg := Message{
 		from:        u.name,
 		body:        fmt.Sprintf("* %s has entered the room", u.name),
 		excludeFrom: true,
+		kind:        joinedMessage,
 	}
 	r.publish(msg)
 	members := r.getOtherMembers(u.name)
-	err := u.SendTxt(fmt.Sprintf("* The room contains: %s\n", strings.Join(members, ", ")))
+	err := u.Send(Message{
+		body:        fmt.Sprintf("* The room contains: %s", strings.Join(members, ", ")),
+		excludeFrom: true,
+		kind:        membersMessage,
+		members:     members,
+	})
 	if err != nil {
 		return fmt.Errorf("cannot list members on join: %w", err)
 	}
+	for _, msg := range backlog {
+		err = u.Send(msg)
+		if err != nil {
+			return fmt.Errorf("cannot replay history on join: %w", err)
+		}
+	}
 	return nil
 }
 
 func (m *Member) SendTxt(txt string) error {
-	_, err := m.conn.w.Write([]byte(txt))
-	if err != nil {
-		return fmt.Errorf("cannot send txt: %w", err)
-	}
-
-	err = m.conn.w.Flush()
-	if err != nil {
-		return fmt.Errorf("failed to flush write: %w", err)
-	}
-
-	return nil
+	return m.conn.WriteText(txt)
 }
 
 func (m *Member) Send(msg Message) error {
+	if w, ok := m.conn.(MessageWriter); ok {
+		return w.WriteMessage(msg)
+	}
+
 	if msg.excludeFrom {
 		return m.SendTxt(fmt.Sprintf("%s\n", msg.body))
 	} else {
@@ -155,7 +373,7 @@ func (m *Member) Send(msg Message) error {
 }
 
 func (m *Member) ReadMemberMessage() (*Message, error) {
-	txt, err := m.conn.r.ReadString('\n')
+	txt, err := m.conn.ReadLine()
 	if err != nil {
 		return nil, err
 	}
@@ -164,7 +382,7 @@ func (m *Member) ReadMemberMessage() (*Message, error) {
 		return nil, errChatMessageTooLong
 	}
 
-	return &Message{from: m.name, body: normalizeReadLine(txt)}, nil
+	return &Message{from: m.name, body: txt}, nil
 }
 
 func (r *ChatRoom) getMembers() []string {
@@ -172,8 +390,10 @@ func (r *ChatRoom) getMembers() []string {
 	defer r.lock.RUnlock()
 
 	var members []string
-	for name := range r.members {
-		members = append(members, name)
+	for name, m := range r.members {
+		if m.state == active {
+			members = append(members, name)
+		}
 	}
 	return members
 }
@@ -191,15 +411,79 @@ func (r *ChatRoom) getOtherMembers(me string) []string {
 	return others
 }
 
+// publish never blocks on a member, messages are queued and members
+// which cannot keep up are handled according to the room slow consumer policy
 func (r *ChatRoom) publish(msg Message) {
+	for _, m := range r.recipients(msg) {
+		if m.enqueue(msg) {
+			continue
+		}
+
+		switch r.policy {
+		case disconnectSlow:
+			log.Printf("disconnecting slow consumer %s", m.name)
+			go r.leave(m, slowConsumerNotice)
+		default:
+			m.dropOldest()
+			if !m.enqueue(msg) {
+				log.Printf("dropped message for slow consumer %s", m.name)
+			}
+		}
+	}
+}
+
+// recipients records the message in history under the same lock as registration,
+// so joining member either gets it replayed or queued but never both
+func (r *ChatRoom) recipients(msg Message) []*Member {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	for username, v := range r.members {
-		if msg.from != username {
-			v.input <- msg
+	if !msg.excludeFrom {
+		r.history.add(msg)
+	}
+
+	members := make([]*Member, 0, len(r.members))
+	for username, m := range r.members {
+		if msg.from != username && m.state == active {
+			members = append(members, m)
 		}
 	}
+	return members
+}
+
+func (m *Member) enqueue(msg Message) bool {
+	select {
+	case m.input <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Member) notify(txt string) {
+	if !m.enqueue(Message{body: txt, excludeFrom: true, kind: noticeMessage}) {
+		log.Printf("dropped notice for slow consumer %s", m.name)
+	}
+}
+
+func (m *Member) dropOldest() {
+	select {
+	case <-m.input:
+	default:
+	}
+}
+
+// disconnect closes member connection which stops both reader and writer,
+// notice is delivered on best effort basis as the member might not read at all
+func (m *Member) disconnect(notice string) {
+	m.closeOnce.Do(func() {
+		if notice != "" {
+			m.conn.SetWriteDeadline(time.Now().Add(slowConsumerNoticeTimeout))
+			m.conn.WriteText(notice)
+		}
+		m.conn.Close()
+		close(m.done)
+	})
 }
 
 func startServer() {
@@ -209,6 +493,41 @@ func startServer() {
 	}
 
 	r := newRoom()
+	if transcriptPath != "" {
+		t, err := openTranscript(transcriptPath)
+		if err != nil {
+			log.Fatalf("transcript failed: %v", err)
+		}
+		defer t.Close()
+
+		err = r.history.restore(t)
+		if err != nil {
+			log.Fatalf("transcript failed: %v", err)
+		}
+	}
+	if banListPath != "" {
+		err := r.mod.loadBans(banListPath)
+		if err != nil {
+			log.Fatalf("moderation failed: %v", err)
+		}
+	}
+	err = r.mod.addOperators(operatorList)
+	if err != nil {
+		log.Fatalf("moderation failed: %v", err)
+	}
+	if profanityPath != "" {
+		words, err := loadWords(profanityPath)
+		if err != nil {
+			log.Fatalf("failed to load profanity list: %v", err)
+		}
+		r.filters = append(r.filters, newProfanityFilter(words))
+	}
+	if wsAddr != "" {
+		go startWebSocketServer(wsAddr, r)
+	}
+	if ircAddr != "" {
+		go startIRCServer(ircAddr, r)
+	}
 
 	for {
 		conn, err := listener.Accept()
@@ -223,65 +542,135 @@ func startServer() {
 
 func newRoom() *ChatRoom {
 	return &ChatRoom{
-		members: make(map[string]*Member),
-		lock:    &sync.RWMutex{},
+		members:   make(map[string]*Member),
+		lock:      &sync.RWMutex{},
+		queueSize: memberQueueSize,
+		policy:    slowConsumer,
+		history:   newHistory(historySize, historyMaxAge),
+		mod:       newModeration(),
+
+		idleTimeout: idleTimeout,
 	}
 }
 
 func handleConnection(c net.Conn, r *ChatRoom) {
+	r.serveMember(newMemberNetwork(c))
+}
+
+// serveMember runs the member session no matter how the member is connected
+func (r *ChatRoom) serveMember(mconn MemberConn) {
 	defer func() {
 		fmt.Print("Closing connection on server\n")
-		c.Close()
+		mconn.Close()
 	}()
 
+	if r.mod.isBannedIP(mconn.RemoteAddr()) {
+		mconn.WriteText(errBanned.Error())
+		return
+	}
+
 	// write to provide username
 	// read username
-	member, err := r.initMember(c)
+	member, err := r.initMember(mconn)
 	if err != nil {
-		if errors.Is(err, errInvalidUsername) || errors.Is(err, errUniqueUsername) {
-			c.Write([]byte(err.Error()))
+		if errors.Is(err, errInvalidUsername) || errors.Is(err, errUniqueUsername) || errors.Is(err, errBanned) {
+			mconn.WriteText(err.Error())
 		} else {
 			log.Printf("member init failed: %v", err)
 		}
 		return
 	}
-	r.registerMember(member)
-	defer r.unregisterMember(member)
-	r.onRegisteredUser(member)
+	r.runMember(member)
+}
+
+// runMember makes reserved member active and blocks until it leaves
+func (r *ChatRoom) runMember(member *Member) {
+	backlog := r.registerMember(member)
+	err := r.onRegisteredUser(member, backlog)
+	if err != nil {
+		log.Printf("member join failed: %v", err)
+		r.leave(member, "")
+		return
+	}
 
-	go r.readMember(member)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		r.readMember(member)
+	}()
+
+	r.writeMember(member)
+	r.leave(member, "")
+	<-readerDone
+}
 
+func (r *ChatRoom) writeMember(m *Member) {
 	for {
 		select {
-		case msg := <-member.input:
-			member.Send(msg)
+		case msg := <-m.input:
+			err := m.Send(msg)
+			if err != nil {
+				log.Printf("sending message to %s failed: %v", m.name, err)
+				return
+			}
+		case <-m.done:
+			return
 		}
 	}
 }
 
 func (r *ChatRoom) readMember(m *Member) {
+	defer r.leave(m, "")
 	for {
 		msg, err := m.ReadMemberMessage()
 		if err != nil {
+			if errors.Is(err, errChatMessageTooLong) || errors.Is(err, errChatMessageLineBreak) {
+				log.Printf("reading message failed: %v", err)
+				continue
+			}
+
 			if err == io.EOF {
 				log.Printf("Client closed connection")
-				r.publish(Message{
-					from:        m.name,
-					body:        fmt.Sprintf("* %s has left the room", m.name),
-					excludeFrom: true,
-				})
-				r.unregisterMember(m)
-				return
-			} else if opError, ok := err.(*net.OpError); ok {
-				if opError.Op == "abc" {
-					log.Printf("abc reading message failed: %v", err)
-				}
-				continue
 			} else {
 				log.Printf("reading message failed: %v", err)
-				continue
 			}
+			return
 		}
-		r.publish(*msg)
+
+		err = r.admit(m)
+		if err != nil {
+			m.notify(fmt.Sprintf("* %v", err))
+			continue
+		}
+		if r.runCommand(m, msg.body) {
+			continue
+		}
+
+		filtered, err := r.filter(m, *msg)
+		if err != nil {
+			m.notify(fmt.Sprintf("* %v", err))
+			continue
+		}
+		r.publish(filtered)
+	}
+}
+
+// runCommand reports whether the message was a command, unknown commands are regular messages
+func (r *ChatRoom) runCommand(m *Member, body string) bool {
+	if !strings.HasPrefix(body, "/") {
+		return false
+	}
+
+	name, args, _ := strings.Cut(body[1:], " ")
+	cmd, ok := commands[name]
+	if !ok {
+		return false
+	}
+
+	err := cmd(r, m, strings.TrimSpace(args))
+	if err != nil {
+		log.Printf("command %s failed: %v", name, err)
+		m.notify(fmt.Sprintf("* /%s failed", name))
 	}
+	return true
 }
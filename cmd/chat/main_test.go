@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func join(t *testing.T, r *ChatRoom, name string) (net.Conn, *bufio.Reader) {
+	server, client := net.Pipe()
+	go handleConnection(server, r)
+
+	reader := bufio.NewReader(client)
+	welcome := readLine(t, reader)
+	require.Contains(t, welcome, "Welcome to budgetchat")
+
+	_, err := client.Write([]byte(name + "\n"))
+	require.NoError(t, err)
+
+	members := readLine(t, reader)
+	require.True(t, strings.HasPrefix(members, "* The room contains"), members)
+
+	return client, reader
+}
+
+func readLine(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSuffix(line, "\n")
+}
+
+func readLines(r *bufio.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSuffix(line, "\n")
+		}
+	}()
+	return lines
+}
+
+func TestSlowConsumerDoesNotBlockRoom(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		policy slowConsumerPolicy
+	}{
+		{
+			desc:   "drop oldest",
+			policy: dropOldest,
+		},
+		{
+			desc:   "disconnect",
+			policy: disconnectSlow,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := newRoom()
+			r.queueSize = 8
+			r.policy = tc.policy
+
+			// joins and never reads again
+			stalled, _ := join(t, r, "stalled")
+			defer stalled.Close()
+
+			fast, fastReader := join(t, r, "fast")
+			defer fast.Close()
+
+			sendersCnt := 3
+			messagesCnt := 200
+			senders := make([]net.Conn, sendersCnt)
+			for i := range senders {
+				conn, reader := join(t, r, fmt.Sprintf("sender%d", i))
+				defer conn.Close()
+				go io.Copy(io.Discard, reader)
+				senders[i] = conn
+			}
+
+			// each sender waits until its message reaches the fast member, so only
+			// the stalled member ever falls behind
+			acks := make([]chan struct{}, sendersCnt)
+			for i := range acks {
+				acks[i] = make(chan struct{}, 1)
+			}
+			leftNotice := make(chan struct{}, 1)
+			go func() {
+				for line := range readLines(fastReader) {
+					var sender, msg int
+					if _, err := fmt.Sscanf(line, "[sender%d] message %d", &sender, &msg); err == nil {
+						acks[sender] <- struct{}{}
+					} else if line == "* stalled has left the room" {
+						leftNotice <- struct{}{}
+					}
+				}
+			}()
+
+			// senders report failures back, require has to be called from the test goroutine
+			errs := make(chan error, sendersCnt)
+			wg := sync.WaitGroup{}
+			wg.Add(sendersCnt)
+			for i, conn := range senders {
+				go func(i int, conn net.Conn) {
+					defer wg.Done()
+					for j := 0; j < messagesCnt; j++ {
+						_, err := conn.Write([]byte(fmt.Sprintf("message %d\n", j)))
+						if err != nil {
+							errs <- fmt.Errorf("sender%d message %d: %w", i, j, err)
+							return
+						}
+						select {
+						case <-acks[i]:
+						case <-time.After(5 * time.Second):
+							errs <- fmt.Errorf("sender%d message %d was not delivered", i, j)
+							return
+						}
+					}
+				}(i, conn)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			if tc.policy == disconnectSlow {
+				select {
+				case <-leftNotice:
+				case <-time.After(5 * time.Second):
+					require.Fail(t, "stalled member was not disconnected")
+				}
+				require.False(t, r.memberNameTaken("stalled"))
+			} else {
+				require.True(t, r.memberNameTaken("stalled"))
+			}
+		})
+	}
+}
+
+func TestPublishDropsOldestMessage(t *testing.T) {
+	r := newRoom()
+	r.policy = dropOldest
+	m := newMember("slow", nil, 2)
+	r.registerMember(m)
+
+	for i := 0; i < 5; i++ {
+		r.publish(Message{from: "other", body: fmt.Sprintf("%d", i)})
+	}
+
+	require.Equal(t, "3", (<-m.input).body)
+	require.Equal(t, "4", (<-m.input).body)
+}
+
+func TestConcurrentJoinsWithTheSameName(t *testing.T) {
+	r := newRoom()
+	clientsCnt := 50
+
+	results := make(chan string, clientsCnt)
+	conns := make(chan net.Conn, clientsCnt)
+	wg := sync.WaitGroup{}
+	wg.Add(clientsCnt)
+	for i := 0; i < clientsCnt; i++ {
+		go func() {
+			defer wg.Done()
+			server, client := net.Pipe()
+			conns <- client
+			go handleConnection(server, r)
+
+			reader := bufio.NewReader(client)
+			if _, err := reader.ReadString('\n'); err != nil {
+				results <- err.Error()
+				return
+			}
+			if _, err := client.Write([]byte("max\n")); err != nil {
+				results <- err.Error()
+				return
+			}
+			// the error is written without a new line before connection gets closed
+			line, _ := reader.ReadString('\n')
+			results <- line
+		}()
+	}
+	wg.Wait()
+	close(results)
+	close(conns)
+	defer func() {
+		for c := range conns {
+			c.Close()
+		}
+	}()
+
+	joined := 0
+	for res := range results {
+		if strings.HasPrefix(res, "* The room contains") {
+			joined++
+		} else {
+			require.Equal(t, errUniqueUsername.Error(), res)
+		}
+	}
+	require.Equal(t, 1, joined)
+	require.Len(t, r.getMembers(), 1)
+}
+
+func TestMembersLifecycle(t *testing.T) {
+	r := newRoom()
+	baseline := runtime.NumGoroutine()
+	membersCnt := 50
+
+	type session struct {
+		name string
+		conn net.Conn
+	}
+	sessions := make(chan session, membersCnt)
+	wg := sync.WaitGroup{}
+	wg.Add(membersCnt)
+	for i := 0; i < membersCnt; i++ {
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("member%d", i)
+			conn, reader := join(t, r, name)
+			go io.Copy(io.Discard, reader)
+			sessions <- session{name: name, conn: conn}
+		}(i)
+	}
+	wg.Wait()
+	close(sessions)
+	require.Len(t, r.getMembers(), membersCnt)
+
+	i := 0
+	for s := range sessions {
+		// members either disconnect or get kicked
+		if i%2 == 0 {
+			s.conn.Close()
+		} else {
+			m, err := r.findMember(s.name)
+			require.NoError(t, err)
+			go r.leave(m, "* bye\n")
+			defer s.conn.Close()
+		}
+		i++
+	}
+
+	require.Eventually(t, func() bool {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+		return len(r.members) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// readers, writers and client side readers are all gone, polling in place
+	// as require.Eventually runs the condition on its own goroutine
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+}
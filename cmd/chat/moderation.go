@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMuteDuration = 10 * time.Minute
+)
+
+var (
+	errBanned          = errors.New("you are banned from this room")
+	errMuted           = errors.New("you are muted")
+	errRateLimited     = errors.New("slow down, you are sending messages too fast")
+	errNotOperator     = errors.New("only operators can do that")
+	errMemberNotInRoom = errors.New("no such member in the room")
+)
+
+// messageFilter is applied to every member message before it gets published,
+// it can rewrite the message or reject it with an error sent back to the member
+type messageFilter func(r *ChatRoom, m *Member, msg Message) (Message, error)
+
+func (r *ChatRoom) filter(m *Member, msg Message) (Message, error) {
+	var err error
+	for _, f := range r.filters {
+		msg, err = f(r, m, msg)
+		if err != nil {
+			return msg, err
+		}
+	}
+	return msg, nil
+}
+
+// admit rejects whatever muted or rate limited members send, commands included
+func (r *ChatRoom) admit(m *Member) error {
+	now := time.Now()
+	if r.mod.isMuted(m.name, now) {
+		return errMuted
+	}
+	if m.limiter != nil && !m.limiter.allow(now) {
+		return errRateLimited
+	}
+	return nil
+}
+
+// newProfanityFilter masks listed words, matching whole words regardless of case
+func newProfanityFilter(words []string) messageFilter {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		quoted = append(quoted, regexp.QuoteMeta(w))
+	}
+	exp := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+
+	return func(r *ChatRoom, m *Member, msg Message) (Message, error) {
+		msg.body = exp.ReplaceAllStringFunc(msg.body, func(w string) string {
+			return strings.Repeat("*", len(w))
+		})
+		return msg, nil
+	}
+}
+
+func loadWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		w := strings.TrimSpace(s.Text())
+		if w == "" || strings.HasPrefix(w, "#") {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words, s.Err()
+}
+
+// tokenBucket allows bursts of up to burst messages refilled at rate per second
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow is called only by the member reader so it needs no locking
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type moderation struct {
+	lock  sync.Mutex
+	muted map[string]time.Time
+
+	bannedNames map[string]bool
+	bannedIPs   map[string]bool
+	// operator name mapped to the only ip it is accepted from
+	operators map[string]string
+
+	rate  float64
+	burst int
+}
+
+func newModeration() *moderation {
+	return &moderation{
+		muted:       make(map[string]time.Time),
+		bannedNames: make(map[string]bool),
+		bannedIPs:   make(map[string]bool),
+		operators:   make(map[string]string),
+		rate:        rateLimit,
+		burst:       rateBurst,
+	}
+}
+
+// loadBans reads ban list with a username or an ip address per line
+func (mod *moderation) loadBans(path string) error {
+	entries, err := loadWords(path)
+	if err != nil {
+		return fmt.Errorf("failed to load ban list: %w", err)
+	}
+
+	for _, e := range entries {
+		if net.ParseIP(e) != nil {
+			mod.bannedIPs[normalizeIP(e)] = true
+		} else {
+			mod.bannedNames[e] = true
+		}
+	}
+	return nil
+}
+
+// addOperators accepts comma separated `name@ip` entries, a name alone would let anyone joining with it moderate
+func (mod *moderation) addOperators(list string) error {
+	for _, op := range strings.Split(list, ",") {
+		op = strings.TrimSpace(op)
+		if op == "" {
+			continue
+		}
+		name, ip, _ := strings.Cut(op, "@")
+		if name == "" || ip == "" {
+			return fmt.Errorf("operator %q is not name@ip", op)
+		}
+		mod.operators[name] = normalizeIP(ip)
+	}
+	return nil
+}
+
+func (mod *moderation) isBannedIP(addr net.Addr) bool {
+	return mod.bannedIPs[remoteIP(addr)]
+}
+
+func (mod *moderation) isBannedName(name string) bool {
+	return mod.bannedNames[name]
+}
+
+func (mod *moderation) isOperator(name string, addr net.Addr) bool {
+	ip, ok := mod.operators[name]
+	return ok && ip == remoteIP(addr)
+}
+
+func (mod *moderation) mute(name string, until time.Time) {
+	mod.lock.Lock()
+	defer mod.lock.Unlock()
+	mod.muted[name] = until
+}
+
+func (mod *moderation) isMuted(name string, now time.Time) bool {
+	mod.lock.Lock()
+	defer mod.lock.Unlock()
+
+	until, ok := mod.muted[name]
+	if ok && now.After(until) {
+		delete(mod.muted, name)
+		return false
+	}
+	return ok
+}
+
+func (mod *moderation) newLimiter() *tokenBucket {
+	if mod.rate <= 0 {
+		return nil
+	}
+	return newTokenBucket(mod.rate, mod.burst)
+}
+
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return normalizeIP(addr.String())
+	}
+	return normalizeIP(host)
+}
+
+// normalizeIP writes ips in their canonical form so ipv4 mapped and differently written ipv6 addresses compare equal,
+// anything else is kept as it is
+func normalizeIP(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return s
+}
+
+func (r *ChatRoom) findMember(name string) (*Member, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	m, ok := r.members[name]
+	if !ok || m.state != active {
+		return nil, errMemberNotInRoom
+	}
+	return m, nil
+}
+
+func kickCommand(r *ChatRoom, m *Member, name string) error {
+	if !m.operator {
+		m.notify(fmt.Sprintf("* %v", errNotOperator))
+		return nil
+	}
+
+	target, err := r.findMember(name)
+	if err != nil {
+		m.notify(fmt.Sprintf("* %s: %v", name, err))
+		return nil
+	}
+
+	r.publish(Message{
+		from:        target.name,
+		body:        fmt.Sprintf("* %s was kicked by %s", target.name, m.name),
+		excludeFrom: true,
+		kind:        noticeMessage,
+	})
+	go r.leave(target, fmt.Sprintf("* you were kicked by %s\n", m.name))
+	return nil
+}
+
+func muteCommand(r *ChatRoom, m *Member, args string) error {
+	if !m.operator {
+		m.notify(fmt.Sprintf("* %v", errNotOperator))
+		return nil
+	}
+
+	name, durationArg, _ := strings.Cut(args, " ")
+	duration := defaultMuteDuration
+	if durationArg != "" {
+		var err error
+		duration, err = time.ParseDuration(strings.TrimSpace(durationArg))
+		if err != nil {
+			m.notify("* usage: /mute <name> [duration]")
+			return nil
+		}
+	}
+
+	target, err := r.findMember(name)
+	if err != nil {
+		m.notify(fmt.Sprintf("* %s: %v", name, err))
+		return nil
+	}
+
+	r.mod.mute(target.name, time.Now().Add(duration))
+	target.notify(fmt.Sprintf("* you were muted by %s for %s", m.name, duration))
+	m.notify(fmt.Sprintf("* %s muted for %s", target.name, duration))
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 2)
+	now := b.last
+
+	require.True(t, b.allow(now))
+	require.True(t, b.allow(now))
+	require.False(t, b.allow(now))
+
+	require.False(t, b.allow(now.Add(100*time.Millisecond)))
+	require.True(t, b.allow(now.Add(600*time.Millisecond)))
+	require.False(t, b.allow(now.Add(600*time.Millisecond)))
+}
+
+func TestProfanityFilter(t *testing.T) {
+	f := newProfanityFilter([]string{"darn", "heck"})
+
+	msg, err := f(newRoom(), nil, Message{from: "max", body: "Darn it, what the heck? darnation"})
+	require.NoError(t, err)
+	require.Equal(t, "**** it, what the ****? darnation", msg.body)
+}
+
+func TestLoadBansAndOperators(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans")
+	require.NoError(t, os.WriteFile(path, []byte("# spammers\nbob\n10.0.0.7\n\n::1\n::ffff:10.0.0.6\n"), 0644))
+
+	mod := newModeration()
+	require.NoError(t, mod.loadBans(path))
+	require.NoError(t, mod.addOperators("max@10.0.0.8, charlie@127.0.0.1, dora@::ffff:10.0.0.9, eve@2001:DB8:0::0:1"))
+	require.Error(t, mod.addOperators("bob"))
+	require.Error(t, mod.addOperators("@10.0.0.8"))
+
+	require.True(t, mod.isBannedName("bob"))
+	require.False(t, mod.isBannedName("max"))
+	require.True(t, mod.isBannedIP(&net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 5000}))
+	require.True(t, mod.isBannedIP(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 5000}))
+	require.True(t, mod.isBannedIP(&net.TCPAddr{IP: net.ParseIP("10.0.0.6"), Port: 5000}))
+	require.False(t, mod.isBannedIP(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.8"), Port: 5000}
+	require.True(t, mod.isOperator("max", remote))
+	require.False(t, mod.isOperator("max", local))
+	require.True(t, mod.isOperator("charlie", local))
+	require.False(t, mod.isOperator("charlie", remote))
+	require.False(t, mod.isOperator("bob", local))
+	// ips are compared in their canonical form
+	require.True(t, mod.isOperator("dora", &net.TCPAddr{IP: net.ParseIP("10.0.0.9"), Port: 5000}))
+	require.True(t, mod.isOperator("eve", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}))
+}
+
+func TestBannedNameRejected(t *testing.T) {
+	r := newRoom()
+	r.mod.bannedNames["bob"] = true
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go handleConnection(server, r)
+
+	buf := make([]byte, 1024)
+	_, err := client.Read(buf) // welcome
+	require.NoError(t, err)
+	_, err = client.Write([]byte("bob\n"))
+	require.NoError(t, err)
+
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, errBanned.Error(), string(buf[:n]))
+}
+
+func TestKick(t *testing.T) {
+	r := newRoom()
+	require.NoError(t, r.mod.addOperators("max@pipe")) // net.Pipe connections come from the "pipe" address
+
+	max, maxReader := join(t, r, "max")
+	defer max.Close()
+	charlie, charlieReader := join(t, r, "charlie")
+	defer charlie.Close()
+	require.Equal(t, "* charlie has entered the room", readLine(t, maxReader))
+
+	_, err := charlie.Write([]byte("/kick max\n"))
+	require.NoError(t, err)
+	require.Equal(t, "* only operators can do that", readLine(t, charlieReader))
+
+	_, err = max.Write([]byte("/kick charlie\n"))
+	require.NoError(t, err)
+	require.Equal(t, "* you were kicked by max", readLine(t, charlieReader))
+	require.Equal(t, "* charlie was kicked by max", readLine(t, maxReader))
+	require.Equal(t, "* charlie has left the room", readLine(t, maxReader))
+	require.False(t, r.memberNameTaken("charlie"))
+}
+
+func TestMute(t *testing.T) {
+	r := newRoom()
+	require.NoError(t, r.mod.addOperators("max@pipe")) // net.Pipe connections come from the "pipe" address
+
+	max, maxReader := join(t, r, "max")
+	defer max.Close()
+	charlie, charlieReader := join(t, r, "charlie")
+	defer charlie.Close()
+	require.Equal(t, "* charlie has entered the room", readLine(t, maxReader))
+
+	_, err := max.Write([]byte("/mute charlie 1m\n"))
+	require.NoError(t, err)
+	require.Equal(t, "* charlie muted for 1m0s", readLine(t, maxReader))
+	require.Equal(t, "* you were muted by max for 1m0s", readLine(t, charlieReader))
+
+	_, err = charlie.Write([]byte("hello?\n"))
+	require.NoError(t, err)
+	require.Equal(t, "* you are muted", readLine(t, charlieReader))
+	_, err = charlie.Write([]byte("/history hello\n"))
+	require.NoError(t, err)
+	require.Equal(t, "* you are muted", readLine(t, charlieReader))
+
+	_, err = max.Write([]byte("still there?\n"))
+	require.NoError(t, err)
+	require.Equal(t, "[max] still there?", readLine(t, charlieReader))
+}
+
+func TestRateLimit(t *testing.T) {
+	r := newRoom()
+	r.mod.rate = 0.1
+	r.mod.burst = 2
+
+	max, maxReader := join(t, r, "max")
+	defer max.Close()
+	charlie, charlieReader := join(t, r, "charlie")
+	defer charlie.Close()
+
+	for _, msg := range []string{"one\n", "two\n", "three\n"} {
+		_, err := charlie.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "* slow down, you are sending messages too fast", readLine(t, charlieReader))
+
+	require.Equal(t, "* charlie has entered the room", readLine(t, maxReader))
+	require.Equal(t, "[charlie] one", readLine(t, maxReader))
+	require.Equal(t, "[charlie] two", readLine(t, maxReader))
+
+	// commands take tokens too
+	_, err := charlie.Write([]byte("/history one\n"))
+	require.NoError(t, err)
+	require.Equal(t, "* slow down, you are sending messages too fast", readLine(t, charlieReader))
+}
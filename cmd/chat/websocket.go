@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// frames longer than that are rejected without being buffered
+	wsMaxPayloadBytes = 1100
+)
+
+// WSMemberNet carries every chat line in its own WebSocket text frame
+type WSMemberNet struct {
+	ws   *websocket.Conn
+	addr net.Addr
+	// the read deadline moves with every frame so only idle members are disconnected
+	idleTimeout time.Duration
+}
+
+func newWSMemberNetwork(ws *websocket.Conn, idleTimeout time.Duration) *WSMemberNet {
+	ws.MaxPayloadBytes = wsMaxPayloadBytes
+
+	// websocket remote address is the origin, ban list needs the peer ip
+	var addr net.Addr = ws.RemoteAddr()
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr); err == nil {
+		addr = tcpAddr
+	}
+
+	return &WSMemberNet{
+		ws:          ws,
+		addr:        addr,
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (n *WSMemberNet) ReadLine() (string, error) {
+	n.ws.SetReadDeadline(time.Now().Add(n.idleTimeout))
+	var txt string
+	err := websocket.Message.Receive(n.ws, &txt)
+	if err != nil {
+		if errors.Is(err, websocket.ErrFrameTooLarge) {
+			return "", errChatMessageTooLong
+		}
+		return "", err
+	}
+	txt = strings.TrimSuffix(normalizeReadLine(txt), "\r")
+	// other members read lines, a line break would let the rest pass as someone else's message
+	if strings.ContainsAny(txt, "\r\n") {
+		return "", errChatMessageLineBreak
+	}
+	return txt, nil
+}
+
+func (n *WSMemberNet) WriteText(txt string) error {
+	err := websocket.Message.Send(n.ws, normalizeReadLine(txt))
+	if err != nil {
+		return fmt.Errorf("cannot send txt: %w", err)
+	}
+	return nil
+}
+
+func (n *WSMemberNet) SetWriteDeadline(t time.Time) error {
+	return n.ws.SetWriteDeadline(t)
+}
+
+func (n *WSMemberNet) RemoteAddr() net.Addr {
+	return n.addr
+}
+
+func (n *WSMemberNet) Close() error {
+	return n.ws.Close()
+}
+
+// newWebSocketHandler serves the browser client on `/` and joins WebSocket connections on `/ws` to the room
+func newWebSocketHandler(r *ChatRoom) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(func(ws *websocket.Conn) {
+		r.serveMember(newWSMemberNetwork(ws, r.idleTimeout))
+	}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, chatPage)
+	})
+	return mux
+}
+
+func startWebSocketServer(addr string, r *ChatRoom) {
+	err := http.ListenAndServe(addr, newWebSocketHandler(r))
+	if err != nil {
+		log.Printf("websocket listener failed: %v", err)
+	}
+}
+
+const chatPage = `<!DOCTYPE html>
+<html>
+<head><title>budgetchat</title></head>
+<body>
+<pre id="log"></pre>
+<form id="form"><input id="line" size="80" autofocus><button>send</button></form>
+<script>
+const log = document.getElementById("log");
+const line = document.getElementById("line");
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+ws.onmessage = (e) => { log.textContent += e.data + "\n"; };
+ws.onclose = () => { log.textContent += "* disconnected\n"; };
+document.getElementById("form").onsubmit = (e) => {
+  e.preventDefault();
+  ws.send(line.value);
+  line.value = "";
+};
+</script>
+</body>
+</html>
+`
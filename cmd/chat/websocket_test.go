@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func joinWebSocket(t *testing.T, url string, name string) *websocket.Conn {
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	require.NoError(t, err)
+
+	require.Contains(t, receive(t, ws), "Welcome to budgetchat")
+	require.NoError(t, websocket.Message.Send(ws, name))
+	return ws
+}
+
+func receive(t *testing.T, ws *websocket.Conn) string {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var txt string
+	require.NoError(t, websocket.Message.Receive(ws, &txt))
+	return txt
+}
+
+func TestWebSocketBridge(t *testing.T) {
+	r := newRoom()
+	srv := httptest.NewServer(newWebSocketHandler(r))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	tcp, tcpReader := join(t, r, "max")
+	defer tcp.Close()
+
+	ws := joinWebSocket(t, url, "browser")
+	defer ws.Close()
+	require.Equal(t, "* The room contains: max", receive(t, ws))
+	require.Equal(t, "* browser has entered the room", readLine(t, tcpReader))
+
+	require.NoError(t, websocket.Message.Send(ws, "hi from the browser"))
+	require.Equal(t, "[browser] hi from the browser", readLine(t, tcpReader))
+
+	_, err := tcp.Write([]byte("hi from nc\n"))
+	require.NoError(t, err)
+	require.Equal(t, "[max] hi from nc", receive(t, ws))
+
+	ws.Close()
+	require.Equal(t, "* browser has left the room", readLine(t, tcpReader))
+}
+
+func TestWebSocketUsernameValidation(t *testing.T) {
+	r := newRoom()
+	srv := httptest.NewServer(newWebSocketHandler(r))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	tcp, _ := join(t, r, "max")
+	defer tcp.Close()
+
+	ws := joinWebSocket(t, url, "max")
+	defer ws.Close()
+	require.Equal(t, errUniqueUsername.Error(), receive(t, ws))
+
+	ws = joinWebSocket(t, url, "not valid!")
+	defer ws.Close()
+	require.Equal(t, errInvalidUsername.Error(), receive(t, ws))
+}
+
+func TestWebSocketRejectsLineBreaks(t *testing.T) {
+	r := newRoom()
+	srv := httptest.NewServer(newWebSocketHandler(r))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	tcp, tcpReader := join(t, r, "max")
+	defer tcp.Close()
+
+	ws := joinWebSocket(t, url, "browser")
+	defer ws.Close()
+	require.Equal(t, "* The room contains: max", receive(t, ws))
+	require.Equal(t, "* browser has entered the room", readLine(t, tcpReader))
+
+	for _, frame := range []string{"hi\n[alice] fake", "hi\r[alice] fake", "hi\r\n[alice] fake\n"} {
+		require.NoError(t, websocket.Message.Send(ws, frame))
+	}
+	require.NoError(t, websocket.Message.Send(ws, "hi\r\n"))
+	require.Equal(t, "[browser] hi", readLine(t, tcpReader))
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	r := newRoom()
+	r.idleTimeout = 300 * time.Millisecond
+	srv := httptest.NewServer(newWebSocketHandler(r))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	tcp, tcpReader := join(t, r, "max")
+	defer tcp.Close()
+
+	ws := joinWebSocket(t, url, "browser")
+	defer ws.Close()
+	require.Equal(t, "* browser has entered the room", readLine(t, tcpReader))
+
+	// active members outlive the timeout
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, websocket.Message.Send(ws, "still here"))
+		require.Equal(t, "[browser] still here", readLine(t, tcpReader))
+	}
+	require.Equal(t, "* browser has left the room", readLine(t, tcpReader))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// requests starting with it are commands, unknown commands are still plain keys,
+	// keys starting with it are escaped by doubling it
+	commandPrefix = "!"
+	// requests and responses are shorter than 1000 bytes
+	maxDatagramSize = 999
+)
+
+var (
+	errNotInteger      = errors.New("value is not an integer")
+	errIntegerOverflow = errors.New("increment overflows value")
+)
+
+type DeleteCmd struct {
+	Key string
+}
+
+type InsertWithTTLCmd struct {
+	Key   string
+	Value string
+	TTL   time.Duration
+}
+
+// CompareAndSwapCmd writes Value only when the current value equals Expected, missing keys equal empty value
+type CompareAndSwapCmd struct {
+	Key      string
+	Expected string
+	Value    string
+}
+
+type IncrementCmd struct {
+	Key   string
+	Delta int64
+}
+
+type ScanQuery struct {
+	Prefix string
+}
+
+// InvalidCommand answers a known command with malformed arguments
+type InvalidCommand struct {
+	Reason string
+}
+
+// getCommand parses `!del <key>`, `!setex <ttl> <key>=<value>`, `!cas <key>\n<expected>\n<value>`,
+// `!incr <delta> <key>` and `!keys <prefix>`
+func getCommand(input string) (Action, bool) {
+	name, args, _ := strings.Cut(input[len(commandPrefix):], " ")
+
+	action, ok := parseCommand(name, args)
+	if !ok {
+		return nil, false
+	}
+
+	var key string
+	switch a := action.(type) {
+	case *DeleteCmd:
+		key = a.Key
+	case *InsertWithTTLCmd:
+		key = a.Key
+	case *CompareAndSwapCmd:
+		key = a.Key
+	case *IncrementCmd:
+		key = a.Key
+	default:
+		return action, true
+	}
+	if err := validateKey(key); err != nil {
+		return &InvalidCommand{Reason: fmt.Sprintf("%s: %v", key, err)}, true
+	}
+	return action, true
+}
+
+func parseCommand(name string, args string) (Action, bool) {
+	switch name {
+	case "del":
+		return &DeleteCmd{Key: args}, true
+	case "setex":
+		ttlArg, kv, ok := strings.Cut(args, " ")
+		key, value, hasValue := strings.Cut(kv, "=")
+		ttl, err := time.ParseDuration(ttlArg)
+		if !ok || !hasValue || err != nil || ttl <= 0 {
+			return &InvalidCommand{Reason: "usage: !setex <ttl> <key>=<value>"}, true
+		}
+		return &InsertWithTTLCmd{Key: key, Value: value, TTL: ttl}, true
+	case "cas":
+		// key and expected value end with a new line, the new value takes the rest
+		key, rest, ok := strings.Cut(args, "\n")
+		expected, value, hasValue := strings.Cut(rest, "\n")
+		if !ok || !hasValue {
+			return &InvalidCommand{Reason: "usage: !cas <key>\\n<expected>\\n<value>"}, true
+		}
+		return &CompareAndSwapCmd{Key: key, Expected: expected, Value: value}, true
+	case "incr":
+		deltaArg, key, ok := strings.Cut(args, " ")
+		delta, err := strconv.ParseInt(deltaArg, 10, 64)
+		if !ok || err != nil {
+			return &InvalidCommand{Reason: "usage: !incr <delta> <key>"}, true
+		}
+		return &IncrementCmd{Key: key, Delta: delta}, true
+	case "keys":
+		return &ScanQuery{Prefix: args}, true
+	}
+	return nil, false
+}
+
+func (s *Store) Delete(c *DeleteCmd) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.s[c.Key]; !ok {
+		return nil
+	}
+	err := s.applyRecord(walRecord{Op: walDelete, Key: c.Key})
+	if err != nil {
+		return err
+	}
+	if s.onWrite != nil {
+		s.onWrite(c.Key, entry{}, true)
+	}
+	return nil
+}
+
+func (s *Store) InsertWithTTL(c *InsertWithTTLCmd) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.set(c.Key, entry{value: c.Value, expires: time.Now().Add(c.TTL)})
+}
+
+// CompareAndSwap returns the value stored once it is done and whether it was swapped
+func (s *Store) CompareAndSwap(c *CompareAndSwapCmd) (string, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, _ := s.get(c.Key, time.Now())
+	if current.value != c.Expected {
+		return current.value, false, nil
+	}
+
+	err := s.set(c.Key, entry{value: c.Value})
+	if err != nil {
+		return current.value, false, err
+	}
+	return c.Value, true, nil
+}
+
+// Increment treats missing keys as zero and keeps the expiry of existing ones
+func (s *Store) Increment(c *IncrementCmd) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, ok := s.get(c.Key, time.Now())
+	var v int64
+	if ok {
+		var err error
+		v, err = strconv.ParseInt(current.value, 10, 64)
+		if err != nil {
+			return 0, errNotInteger
+		}
+	}
+
+	if (c.Delta > 0 && v > math.MaxInt64-c.Delta) || (c.Delta < 0 && v < math.MinInt64-c.Delta) {
+		return 0, errIntegerOverflow
+	}
+	v += c.Delta
+
+	err := s.set(c.Key, entry{value: strconv.FormatInt(v, 10), expires: current.expires})
+	if err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+func (s *Store) Scan(q *ScanQuery) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := time.Now()
+	keys := make([]string, 0)
+	for k, e := range s.s {
+		if strings.HasPrefix(k, q.Prefix) && !e.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+func (c *DeleteCmd) Run(s *Store) (*Result, error) {
+	err := s.Delete(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Op: NoOp}, nil
+}
+
+func (c *InsertWithTTLCmd) Run(s *Store) (*Result, error) {
+	err := s.InsertWithTTL(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Op: NoOp}, nil
+}
+
+func (c *CompareAndSwapCmd) Run(s *Store) (*Result, error) {
+	val, swapped, err := s.CompareAndSwap(c)
+	if err != nil {
+		return nil, err
+	}
+
+	status := "fail"
+	if swapped {
+		status = "ok"
+	}
+	return &Result{Op: Send, Payload: fmt.Sprintf("!cas %s %s=%s", status, c.Key, val)}, nil
+}
+
+func (c *IncrementCmd) Run(s *Store) (*Result, error) {
+	val, err := s.Increment(c)
+	if errors.Is(err, errNotInteger) || errors.Is(err, errIntegerOverflow) {
+		return &Result{Op: Send, Payload: fmt.Sprintf("!err %s: %v", c.Key, err)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Op: Send, Payload: fmt.Sprintf("%s=%d", c.Key, val)}, nil
+}
+
+// Run lists keys separated by new lines, split across `!keys <n>/<total>\n` datagrams
+func (q *ScanQuery) Run(s *Store) (*Result, error) {
+	return &Result{Op: SendFragments, Fragments: fragment("!keys", strings.Join(s.Scan(q), "\n"))}, nil
+}
+
+func (c *InvalidCommand) Run(s *Store) (*Result, error) {
+	return &Result{Op: Send, Payload: "!err " + c.Reason}, nil
+}
+
+// fragment splits body into datagrams prefixed with their position, clients concatenate bodies in order
+func fragment(name string, body string) []string {
+	// every fragment leaves room for the longest header, the one of the last fragment
+	total, chunk := 1, 0
+	for {
+		chunk = maxDatagramSize - len(fragmentHeader(name, total, total))
+		n := (len(body) + chunk - 1) / chunk
+		if n <= total {
+			break
+		}
+		total = n
+	}
+
+	fragments := make([]string, 0, total)
+	for i := 1; i <= total; i++ {
+		size := chunk
+		if size > len(body) {
+			size = len(body)
+		}
+		fragments = append(fragments, fragmentHeader(name, i, total)+body[:size])
+		body = body[size:]
+	}
+	return fragments
+}
+
+func fragmentHeader(name string, i int, total int) string {
+	return fmt.Sprintf("%s %d/%d\n", name, i, total)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func dialStore(t *testing.T) *net.UDPConn {
+	s, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("localhost:%d", serverPort))
+	require.NoError(t, err)
+	c, err := net.DialUDP("udp4", nil, s)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func receive(t *testing.T, c *net.UDPConn) string {
+	c.SetReadDeadline(time.Now().Add(time.Second * 2))
+	buffer := make([]byte, 1000)
+	n, _, err := c.ReadFromUDP(buffer)
+	require.NoError(t, err)
+	return string(buffer[0:n])
+}
+
+func TestCommands(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		requests []string
+		expResp  string
+	}{
+		{
+			desc:     "delete",
+			requests: []string{"del1=foo", "!del del1", "del1"},
+			expResp:  "del1=",
+		},
+		{
+			desc:     "delete missing key",
+			requests: []string{"!del del2", "del2"},
+			expResp:  "del2=",
+		},
+		{
+			desc:     "set with ttl before expiry",
+			requests: []string{"!setex 1m ttl1=foo=bar", "ttl1"},
+			expResp:  "ttl1=foo=bar",
+		},
+		{
+			desc:     "set with invalid ttl",
+			requests: []string{"!setex soon ttl2=foo"},
+			expResp:  "!err usage: !setex <ttl> <key>=<value>",
+		},
+		{
+			desc:     "compare and swap",
+			requests: []string{"cas1=old", "!cas cas1\nold\nnew\nline"},
+			expResp:  "!cas ok cas1=new\nline",
+		},
+		{
+			desc:     "compare and swap mismatch",
+			requests: []string{"cas2=current", "!cas cas2\nold\nnew"},
+			expResp:  "!cas fail cas2=current",
+		},
+		{
+			desc:     "compare and swap missing key",
+			requests: []string{"!del cas3", "!cas cas3\n\ncreated"},
+			expResp:  "!cas ok cas3=created",
+		},
+		{
+			desc:     "increment missing key",
+			requests: []string{"!del counter1", "!incr 5 counter1"},
+			expResp:  "counter1=5",
+		},
+		{
+			desc:     "increment",
+			requests: []string{"counter2=44", "!incr -2 counter2"},
+			expResp:  "counter2=42",
+		},
+		{
+			desc:     "increment non integer",
+			requests: []string{"counter3=foo", "!incr 1 counter3"},
+			expResp:  "!err counter3: value is not an integer",
+		},
+		{
+			desc:     "unknown command is a key",
+			requests: []string{"!nope=value", "!nope"},
+			expResp:  "!nope=value",
+		},
+		{
+			desc:     "escaped command name is a key",
+			requests: []string{"!!del=value", "!!del"},
+			expResp:  "!del=value",
+		},
+		{
+			desc:     "escaped key is deleted by a command",
+			requests: []string{"!!keys=value", "!del !keys", "!!keys"},
+			expResp:  "!keys=",
+		},
+		{
+			desc:     "escaped prefix is kept once",
+			requests: []string{"!!!cas=value", "!!!cas"},
+			expResp:  "!!cas=value",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := dialStore(t)
+			for _, r := range tc.requests {
+				_, err := c.Write([]byte(r))
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expResp, receive(t, c))
+		})
+	}
+}
+
+func TestScanFragments(t *testing.T) {
+	c := dialStore(t)
+
+	var keys []string
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("scan/%03d", i)
+		keys = append(keys, key)
+		_, err := c.Write([]byte(key + "=v"))
+		require.NoError(t, err)
+	}
+	c.Write([]byte("other=v"))
+	// requests for different keys can be handled in any order
+	for _, key := range keys {
+		c.Write([]byte(key))
+		require.Equal(t, key+"=v", receive(t, c))
+	}
+
+	_, err := c.Write([]byte("!keys scan/"))
+	require.NoError(t, err)
+
+	var body strings.Builder
+	for i, total := 1, 1; i <= total; i++ {
+		resp := receive(t, c)
+		require.LessOrEqual(t, len(resp), maxDatagramSize)
+
+		header, fragment, ok := strings.Cut(resp, "\n")
+		require.True(t, ok)
+		var n int
+		_, err := fmt.Sscanf(header, "!keys %d/%d", &n, &total)
+		require.NoError(t, err)
+		require.Equal(t, i, n)
+		body.WriteString(fragment)
+	}
+	require.Equal(t, strings.Join(keys, "\n"), body.String())
+}
+
+func TestFragment(t *testing.T) {
+	require.Equal(t, []string{"!keys 1/1\n"}, fragment("!keys", ""))
+
+	body := strings.Repeat("x", 10*maxDatagramSize)
+	fragments := fragment("!keys", body)
+	require.Len(t, fragments, 11)
+
+	var joined strings.Builder
+	for i, f := range fragments {
+		require.LessOrEqual(t, len(f), maxDatagramSize)
+		require.True(t, strings.HasPrefix(f, fragmentHeader("!keys", i+1, 11)))
+		joined.WriteString(strings.TrimPrefix(f, fragmentHeader("!keys", i+1, 11)))
+	}
+	require.Equal(t, body, joined.String())
+}
+
+func TestExpiry(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertWithTTL(&InsertWithTTLCmd{Key: "short", Value: "v", TTL: 50 * time.Millisecond}))
+	require.NoError(t, s.InsertWithTTL(&InsertWithTTLCmd{Key: "long", Value: "v", TTL: time.Hour}))
+	_, err = s.Increment(&IncrementCmd{Key: "long", Delta: 1})
+	require.Equal(t, errNotInteger, err)
+	require.Equal(t, []string{"long", "short"}, s.Scan(&ScanQuery{}))
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = s.Read(&ReadQuery{Key: "short"})
+	require.Equal(t, errNotFound, err)
+	require.Equal(t, []string{"long"}, s.Scan(&ScanQuery{}))
+
+	require.NoError(t, s.Delete(&DeleteCmd{Key: "long"}))
+	require.NoError(t, s.InsertWithTTL(&InsertWithTTLCmd{Key: "restored", Value: "v", TTL: time.Hour}))
+
+	// expiry survives restarts
+	s, err = OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, []string{"restored"}, s.Scan(&ScanQuery{}))
+	require.True(t, s.s["restored"].expires.After(time.Now().Add(time.Minute)))
+}
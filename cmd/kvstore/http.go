@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newHTTPHandler serves `GET/PUT/DELETE /kv/{key}`, values are sent as raw request and response bodies
+// and PUT accepts an optional `ttl` query parameter, e.g. `?ttl=30s`. Clients over the limit get 429, limiter is nil
+// when requests are not limited
+func newHTTPHandler(store *Store, limiter *rateLimiter) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kv/", func(w http.ResponseWriter, req *http.Request) {
+		if !limiter.allow(clientAddr(req), time.Now()) {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		key := strings.TrimPrefix(req.URL.Path, "/kv/")
+
+		switch req.Method {
+		case http.MethodGet:
+			getValue(w, store, key)
+		case http.MethodPut:
+			putValue(w, req, store, key)
+		case http.MethodDelete:
+			deleteValue(w, store, key)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// clientAddr is the peer of the request, requests with an unreadable one share a limit
+func clientAddr(req *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+func getValue(w http.ResponseWriter, store *Store, key string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if key == versionKey {
+		fmt.Fprint(w, ProductVersion)
+		return
+	}
+
+	// udp reads cannot tell missing keys from empty values, http tells them apart
+	val, err := store.Read(&ReadQuery{Key: key})
+	if errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Fprint(w, val)
+}
+
+func putValue(w http.ResponseWriter, req *http.Request, store *Store, key string) {
+	if !validKey(w, key) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxDatagramSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// values have to be readable over udp too
+	if len(key)+1+len(body) > maxDatagramSize {
+		http.Error(w, "key and value are too long", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var action Action = &InsertCmd{Key: key, Value: string(body)}
+	if ttlArg := req.URL.Query().Get("ttl"); ttlArg != "" {
+		ttl, err := time.ParseDuration(ttlArg)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		action = &InsertWithTTLCmd{Key: key, Value: string(body), TTL: ttl}
+	}
+
+	run(w, store, action)
+}
+
+func deleteValue(w http.ResponseWriter, store *Store, key string) {
+	if !validKey(w, key) {
+		return
+	}
+	run(w, store, &DeleteCmd{Key: key})
+}
+
+func validKey(w http.ResponseWriter, key string) bool {
+	err := validateKey(key)
+	switch {
+	case errors.Is(err, errReservedKey):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+	return err == nil
+}
+
+func run(w http.ResponseWriter, store *Store, action Action) {
+	_, err := action.Run(store)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func startHTTPServer(addr string, store *Store, limiter *rateLimiter) {
+	err := http.ListenAndServe(addr, newHTTPHandler(store, limiter))
+	if err != nil {
+		log.Printf("http listener failed: %v", err)
+	}
+}
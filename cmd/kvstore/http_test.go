@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func httpDo(t *testing.T, method string, key string, body string) *http.Response {
+	req, err := http.NewRequest(method, "http://localhost"+httpAddr+"/kv/"+key, strings.NewReader(body))
+	require.NoError(t, err)
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = http.DefaultClient.Do(req)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func httpGet(t *testing.T, key string) string {
+	resp := httpDo(t, http.MethodGet, key, "")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHTTP(t *testing.T) {
+	h := newHTTPHandler(NewStore(), nil)
+
+	for _, tc := range []struct {
+		method  string
+		path    string
+		body    string
+		expCode int
+		expBody string
+	}{
+		{method: "GET", path: "/kv/missing", expCode: http.StatusNotFound},
+		{method: "PUT", path: "/kv/foo", body: "bar=baz", expCode: http.StatusNoContent},
+		{method: "GET", path: "/kv/foo", expCode: http.StatusOK, expBody: "bar=baz"},
+		{method: "PUT", path: "/kv/empty", expCode: http.StatusNoContent},
+		{method: "GET", path: "/kv/empty", expCode: http.StatusOK},
+		{method: "PUT", path: "/kv/nested/key%20space", body: "v", expCode: http.StatusNoContent},
+		{method: "GET", path: "/kv/nested/key%20space", expCode: http.StatusOK, expBody: "v"},
+		{method: "DELETE", path: "/kv/foo", expCode: http.StatusNoContent},
+		{method: "GET", path: "/kv/foo", expCode: http.StatusNotFound},
+		{method: "GET", path: "/kv/version", expCode: http.StatusOK, expBody: ProductVersion},
+		{method: "PUT", path: "/kv/version", body: "hacked", expCode: http.StatusForbidden},
+		{method: "DELETE", path: "/kv/version", expCode: http.StatusForbidden},
+		{method: "PUT", path: "/kv/a=b", body: "v", expCode: http.StatusBadRequest},
+		{method: "PUT", path: "/kv/big", body: strings.Repeat("x", maxDatagramSize), expCode: http.StatusRequestEntityTooLarge},
+		{method: "PUT", path: "/kv/ttl?ttl=never", body: "v", expCode: http.StatusBadRequest},
+		{method: "PUT", path: "/kv/ttl?ttl=1h", body: "v", expCode: http.StatusNoContent},
+		{method: "GET", path: "/kv/ttl", expCode: http.StatusOK, expBody: "v"},
+		{method: "POST", path: "/kv/foo", expCode: http.StatusMethodNotAllowed},
+	} {
+		t.Run(fmt.Sprintf("%s %s", tc.method, tc.path), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+			require.Equal(t, tc.expCode, rec.Code)
+			if tc.expCode == http.StatusOK {
+				require.Equal(t, tc.expBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPRateLimited(t *testing.T) {
+	h := newHTTPHandler(NewStore(), newRateLimiter(0.001, 1))
+
+	for _, tc := range []struct {
+		remote  string
+		expCode int
+	}{
+		{remote: "10.0.0.1:1000", expCode: http.StatusNotFound},
+		{remote: "10.0.0.1:1001", expCode: http.StatusTooManyRequests},
+		{remote: "10.0.0.2:1000", expCode: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/kv/missing", nil)
+		req.RemoteAddr = tc.remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, tc.expCode, rec.Code, tc.remote)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	versionKey = "version"
+
+	// the least recently seen source is forgotten once that many are tracked
+	maxTrackedSources = 10000
+)
+
+var (
+	errReservedKey      = errors.New("key is reserved")
+	errKeyWithDelimiter = errors.New("key cannot contain =")
+	errResponseTooLong  = errors.New("response too long")
+)
+
+// validateKey checks keys of commands, plain inserts cut the key at the first `=` so they never contain it
+func validateKey(key string) error {
+	if key == versionKey {
+		return errReservedKey
+	}
+	if strings.Contains(key, "=") {
+		return errKeyWithDelimiter
+	}
+	return nil
+}
+
+// IgnoredCmd drops a request without an answer, like the spec requires for writes to the version key
+type IgnoredCmd struct {
+	Reason string
+}
+
+// Run does not log, clients could flood the output with ignored requests
+func (c *IgnoredCmd) Run(s *Store) (*Result, error) {
+	return &Result{Op: NoOp}, nil
+}
+
+// checkResponse replaces payloads that do not fit a datagram, a cut value would be mistaken for the stored one
+func checkResponse(payload string) string {
+	if len(payload) > maxDatagramSize {
+		return fmt.Sprintf("!err %v", errResponseTooLong)
+	}
+	return payload
+}
+
+// tokenBucket allows bursts of up to burst requests refilled at rate per second
+type tokenBucket struct {
+	source string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per source ip, ports are ignored as clients pick them freely
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	lock    sync.Mutex
+	buckets map[string]*list.Element
+	// buckets by last use, the most recent first
+	lru *list.List
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (l *rateLimiter) allow(addr net.Addr, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	source := sourceIP(addr)
+	var b *tokenBucket
+	if e, ok := l.buckets[source]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+	} else {
+		if l.lru.Len() >= maxTrackedSources {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).source)
+		}
+		b = &tokenBucket{source: source, tokens: l.burst, last: now}
+		l.buckets[source] = l.lru.PushFront(b)
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func sourceIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,18 +17,29 @@ const (
 	ProductVersion = "Maks Key-Value Store 0.1"
 )
 
-type storage map[string]string
+type storage map[string]entry
+
+type entry struct {
+	value string
+	// zero when the key never expires
+	expires time.Time
+}
 
 type Operation string
 
 type Store struct {
 	s    storage
 	lock sync.RWMutex
+	wal  *writeAheadLog
+	// called under the write lock after every write made by a client of this store
+	onWrite func(key string, e entry, deleted bool)
 }
 
 type Result struct {
 	Op      Operation
 	Payload string
+	// datagrams of a SendFragments response
+	Fragments []string
 }
 
 type InsertCmd struct {
@@ -47,16 +61,55 @@ type Action interface {
 var (
 	errNotFound = errors.New("key not found")
 
-	NoOp Operation = "NoOp"
-	Send Operation = "Send"
+	NoOp          Operation = "NoOp"
+	Send          Operation = "Send"
+	SendFragments Operation = "SendFragments"
+
+	dataDir    = ""
+	durability = DurabilityOptions{
+		Sync:             SyncAlways,
+		SyncInterval:     time.Second,
+		SnapshotInterval: 5 * time.Minute,
+	}
+
+	port = serverPort
+
+	workers = runtime.NumCPU()
+
+	tcpAddr  = ""
+	httpAddr = ""
+
+	rateLimit = 0.0
+	rateBurst = 20
+
+	replicationAddr = ""
+	peers           = ""
+	nodeID          = ""
+	syncInterval    = 30 * time.Second
 )
 
 func main() {
+	flag.IntVar(&port, "port", port, "udp port clients send requests to")
+	flag.StringVar(&tcpAddr, "tcp-addr", tcpAddr, "address of the newline-delimited tcp listener, disabled when empty")
+	flag.StringVar(&httpAddr, "http-addr", httpAddr, "address of the http api serving /kv/{key}, disabled when empty")
+	flag.IntVar(&workers, "workers", workers, "number of requests handled concurrently")
+	flag.Float64Var(&rateLimit, "rate-limit", rateLimit, "requests per second allowed from a single source address, 0 disables the limit")
+	flag.IntVar(&rateBurst, "rate-burst", rateBurst, "requests a source address can send at once before it is limited")
+	flag.StringVar(&dataDir, "data-dir", dataDir, "directory with the write-ahead log and snapshots, in-memory store when empty")
+	flag.Var(&durability.Sync, "fsync", "when the log is synced to disk: always, interval or never")
+	flag.DurationVar(&durability.SyncInterval, "fsync-interval", durability.SyncInterval, "log sync period for the interval fsync policy")
+	flag.DurationVar(&durability.SnapshotInterval, "snapshot-interval", durability.SnapshotInterval, "period of snapshots compacting the log, 0 disables them")
+	flag.StringVar(&replicationAddr, "replication-addr", replicationAddr, "udp address peers replicate to, replication is off when empty")
+	flag.StringVar(&peers, "peers", peers, "comma separated replication addresses of the other nodes")
+	flag.StringVar(&nodeID, "node-id", nodeID, "unique node name in the cluster, defaults to the replication address")
+	flag.DurationVar(&syncInterval, "sync-interval", syncInterval, "period of anti-entropy syncs with peers, 0 syncs only on startup")
+	flag.Parse()
+
 	startServer()
 }
 
 func startServer() {
-	s, err := net.ResolveUDPAddr("udp4", fmt.Sprintf(":%d", serverPort))
+	s, err := net.ResolveUDPAddr("udp4", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Fatalf("udp resolution failed: %v", err)
 	}
@@ -69,36 +122,61 @@ func startServer() {
 	defer conn.Close()
 
 	store := NewStore()
+	if dataDir != "" {
+		store, err = OpenStore(dataDir, durability)
+		if err != nil {
+			log.Fatalf("store recovery failed: %v", err)
+		}
+	}
+	defer store.Close()
 
-	for {
-		buffer := make([]byte, 1000)
-		n, addr, err := conn.ReadFromUDP(buffer)
+	if replicationAddr != "" {
+		r, err := listenReplication(store)
 		if err != nil {
-			fmt.Printf("error occured reading: %v", err)
+			log.Fatalf("replication failed: %v", err)
 		}
+		defer r.Close()
+	}
 
-		data := string(buffer[0:n])
-		action := getAction(data)
+	limiter := newRateLimiter(rateLimit, rateBurst)
+	if tcpAddr != "" {
+		go startTCPServer(tcpAddr, store, limiter)
+	}
+	if httpAddr != "" {
+		go startHTTPServer(httpAddr, store, limiter)
+	}
 
-		res, err := action.Run(store)
+	serve(conn, store, limiter, workers)
+}
+
+// serve answers requests until the connection is closed, limiter is nil when requests are not limited
+func serve(conn *net.UDPConn, store *Store, limiter *rateLimiter, workers int) {
+	pool := newWorkerPool(conn, store, workers)
+	defer pool.close()
+
+	for {
+		// one byte more than allowed tells oversize datagrams apart
+		buffer := make([]byte, maxDatagramSize+1)
+		n, addr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
-			fmt.Printf("error occured running action: %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("error occured reading: %v", err)
+			continue
 		}
 
-		switch res.Op {
-		case NoOp:
+		if n > maxDatagramSize {
+			fmt.Printf("oversize request from %s dropped\n", addr)
+			continue
+		}
+		if !limiter.allow(addr, time.Now()) {
 			continue
-		case Send:
-			_, err = conn.WriteToUDP([]byte(res.Payload), addr)
-			if err != nil {
-				fmt.Printf("error occured writing: %v", err)
-			}
-		default:
-			log.Fatalf("%s opp is not supported", res.Op)
 		}
 
+		data := string(buffer[0:n])
+		pool.dispatch(request{action: getAction(data), addr: addr})
 	}
-
 }
 
 func NewStore() *Store {
@@ -108,23 +186,55 @@ func NewStore() *Store {
 	}
 }
 
-func (s *Store) Insert(c *InsertCmd) {
+func (s *Store) Insert(c *InsertCmd) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.s[c.Key] = c.Value
+
+	return s.set(c.Key, entry{value: c.Value})
+}
+
+// set logs and applies a write, callers hold the write lock
+func (s *Store) set(key string, e entry) error {
+	err := s.applyRecord(setRecord(key, e))
+	if err != nil {
+		return err
+	}
+	if s.onWrite != nil {
+		s.onWrite(key, e, false)
+	}
+	return nil
+}
+
+func (s *Store) applyRecord(rec walRecord) error {
+	if s.wal != nil {
+		err := s.wal.append(rec)
+		if err != nil {
+			return fmt.Errorf("log append failed: %w", err)
+		}
+	}
+	s.s.apply(rec)
+	return nil
+}
+
+// get hides expired keys, callers hold the lock
+func (s *Store) get(key string, now time.Time) (entry, bool) {
+	e, ok := s.s[key]
+	if !ok || e.expired(now) {
+		return entry{}, false
+	}
+	return e, true
 }
 
 func (s *Store) Read(q *ReadQuery) (string, error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
-	var v string
-	var ok bool
-	if v, ok = s.s[q.Key]; !ok {
+	e, ok := s.get(q.Key, time.Now())
+	if !ok {
 		return "", errNotFound
 	}
 
-	return v, nil
+	return e.value, nil
 }
 
 func (s *storage) Version(v *VersionQuery) string {
@@ -132,13 +242,25 @@ func (s *storage) Version(v *VersionQuery) string {
 }
 
 func getAction(input string) Action {
+	if strings.HasPrefix(input, commandPrefix+commandPrefix) {
+		// a doubled prefix escapes keys starting with it, `!!del=x` sets the `!del` key
+		input = input[len(commandPrefix):]
+	} else if strings.HasPrefix(input, commandPrefix) {
+		if action, ok := getCommand(input); ok {
+			return action
+		}
+	}
+
 	if strings.Contains(input, "=") {
 		result := strings.SplitAfterN(input, "=", 2)
 
 		key := result[0][:len(result[0])-1]
+		if key == versionKey {
+			return &IgnoredCmd{Reason: "version is read only"}
+		}
 
 		return &InsertCmd{Key: key, Value: result[1]}
-	} else if input == "version" {
+	} else if input == versionKey {
 		return &VersionQuery{}
 	} else {
 		return &ReadQuery{Key: input}
@@ -146,7 +268,10 @@ func getAction(input string) Action {
 }
 
 func (c *InsertCmd) Run(s *Store) (*Result, error) {
-	s.Insert(c)
+	err := s.Insert(c)
+	if err != nil {
+		return nil, err
+	}
 	return &Result{Op: NoOp}, nil //noop
 }
 
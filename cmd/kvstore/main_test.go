@@ -3,16 +3,45 @@ package main
 import (
 	"fmt"
 	"net"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
-	go startServer()
+	// helper process of the kill test only writes to its own store
+	if os.Getenv("KVSTORE_HELPER_DIR") == "" {
+		tcpAddr = fmt.Sprintf(":%d", serverPort)
+		httpAddr = ":9980"
+		go startServer()
+		waitForServer()
+	}
 	m.Run()
 }
 
+func waitForServer() {
+	s, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("localhost:%d", serverPort))
+	buffer := make([]byte, 1000)
+	for i := 0; i < 100; i++ {
+		c, err := net.DialUDP("udp4", nil, s)
+		if err != nil {
+			continue
+		}
+		c.Write([]byte(versionKey))
+		c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, _, err = c.ReadFromUDP(buffer)
+		c.Close()
+		if err == nil {
+			return
+		}
+		// refused reads fail right away, give the server time to listen
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestClient(t *testing.T) {
 	for _, tc := range []struct {
 		desc    string
@@ -107,3 +136,115 @@ func TestClient(t *testing.T) {
 		})
 	}
 }
+
+func TestLimits(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		requests []string
+		expResp  string
+	}{
+		{
+			desc:     "oversize request is dropped",
+			requests: []string{"big=" + strings.Repeat("x", 996), "big"},
+			expResp:  "big=",
+		},
+		{
+			desc:     "largest request",
+			requests: []string{"max=" + strings.Repeat("x", 995), "max"},
+			expResp:  "max=" + strings.Repeat("x", 995),
+		},
+		{
+			desc:     "version cannot be overwritten",
+			requests: []string{"version=hacked", "version"},
+			expResp:  fmt.Sprintf("version=%s", ProductVersion),
+		},
+		{
+			desc:     "version cannot be deleted",
+			requests: []string{"!del version"},
+			expResp:  "!err version: key is reserved",
+		},
+		{
+			desc:     "command key with delimiter",
+			requests: []string{"!incr 1 a=b"},
+			expResp:  "!err a=b: key cannot contain =",
+		},
+		{
+			desc:     "insert key ends at first delimiter",
+			requests: []string{"a=b=c", "a"},
+			expResp:  "a=b=c",
+		},
+		{
+			desc:     "empty key command",
+			requests: []string{"!setex 1h =empty", ""},
+			expResp:  "=empty",
+		},
+		{
+			desc:     "response longer than a datagram",
+			requests: []string{"!cas long\n\n" + strings.Repeat("x", 988)},
+			expResp:  "!err response too long",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := dialStore(t)
+			for _, r := range tc.requests {
+				_, err := c.Write([]byte(r))
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expResp, receive(t, c))
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	conn := listenLocal(t)
+	defer conn.Close()
+	go serve(conn, NewStore(), newRateLimiter(1, 5), 1)
+
+	c, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := c.Write([]byte("foo"))
+		require.NoError(t, err)
+	}
+
+	answered := 0
+	buffer := make([]byte, 1000)
+	for {
+		c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, _, err := c.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+		answered++
+	}
+	require.Equal(t, 5, answered)
+
+	// another port of the same host shares the limit
+	other, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer other.Close()
+	_, err = other.Write([]byte("foo"))
+	require.NoError(t, err)
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = other.ReadFromUDP(buffer)
+	require.Error(t, err)
+}
+
+func TestRateLimiterTracksBoundedSources(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	now := time.Now()
+	first := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	require.True(t, l.allow(first, now))
+	require.False(t, l.allow(&net.UDPAddr{IP: first.IP, Port: 2}, now))
+
+	for i := 0; i < maxTrackedSources+100; i++ {
+		ip := net.IPv4(10, 1, byte(i>>8), byte(i))
+		require.True(t, l.allow(&net.UDPAddr{IP: ip, Port: i}, now))
+	}
+	require.Equal(t, maxTrackedSources, len(l.buckets))
+	require.Equal(t, maxTrackedSources, l.lru.Len())
+	// the least recently seen source was forgotten
+	require.True(t, l.allow(first, now))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+)
+
+const (
+	workerQueueSize = 128
+)
+
+type request struct {
+	action Action
+	addr   *net.UDPAddr
+	// set for requests reading keys of every worker
+	barrier *barrier
+}
+
+// barrier is queued to every worker, the first worker runs the request once all of them reached it
+// and the others wait until it is answered
+type barrier struct {
+	arrived sync.WaitGroup
+	done    chan struct{}
+}
+
+// workerPool runs requests concurrently, requests of a client for the same key always go to the same worker
+// so they are answered in the order they were received. Scans go to all workers and see every earlier write
+type workerPool struct {
+	conn   *net.UDPConn
+	store  *Store
+	queues []chan request
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(conn *net.UDPConn, store *Store, workers int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &workerPool{
+		conn:   conn,
+		store:  store,
+		queues: make([]chan request, workers),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan request, workerQueueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// dispatch blocks while the worker is busy, datagrams then pile up in the socket buffer,
+// it is called by a single goroutine so barriers reach all workers in the same order
+func (p *workerPool) dispatch(r request) {
+	if _, ok := r.action.(*ScanQuery); ok {
+		r.barrier = &barrier{done: make(chan struct{})}
+		r.barrier.arrived.Add(len(p.queues))
+		for _, q := range p.queues {
+			q <- r
+		}
+		return
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(r.addr.String()))
+	h.Write([]byte{0})
+	h.Write([]byte(actionKey(r.action)))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- r
+}
+
+// close waits for queued requests to be answered
+func (p *workerPool) close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+func (p *workerPool) work(queue chan request) {
+	defer p.wg.Done()
+	runsBarriers := queue == p.queues[0]
+	for r := range queue {
+		if r.barrier == nil {
+			p.handle(r)
+			continue
+		}
+
+		r.barrier.arrived.Done()
+		if runsBarriers {
+			r.barrier.arrived.Wait()
+			p.handle(r)
+			close(r.barrier.done)
+		} else {
+			<-r.barrier.done
+		}
+	}
+}
+
+func (p *workerPool) handle(r request) {
+	for _, resp := range execute(r.action, p.store) {
+		_, err := p.conn.WriteToUDP([]byte(resp), r.addr)
+		if err != nil {
+			fmt.Printf("error occured writing: %v", err)
+			return
+		}
+	}
+}
+
+// execute runs an action and returns the responses for the client, it is shared by all transports
+func execute(a Action, s *Store) []string {
+	res, err := a.Run(s)
+	if err != nil {
+		fmt.Printf("error occured running action: %v", err)
+		return nil
+	}
+
+	switch res.Op {
+	case NoOp:
+		return nil
+	case Send:
+		return []string{checkResponse(res.Payload)}
+	case SendFragments:
+		return res.Fragments
+	default:
+		fmt.Printf("%s opp is not supported\n", res.Op)
+		return nil
+	}
+}
+
+// actionKey is the key a request reads or writes, requests without one share the empty key, scans use barriers
+func actionKey(a Action) string {
+	switch a := a.(type) {
+	case *InsertCmd:
+		return a.Key
+	case *ReadQuery:
+		return a.Key
+	case *DeleteCmd:
+		return a.Key
+	case *InsertWithTTLCmd:
+		return a.Key
+	case *CompareAndSwapCmd:
+		return a.Key
+	case *IncrementCmd:
+		return a.Key
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startLocalServer(t testing.TB, workers int) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go serve(conn, NewStore(), nil, workers)
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestPoolKeepsPerKeyOrder(t *testing.T) {
+	addr := startLocalServer(t, 8)
+	c, err := net.DialUDP("udp4", nil, addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	const keys, increments = 4, 50
+	for i := 0; i < increments; i++ {
+		for k := 0; k < keys; k++ {
+			_, err := c.Write([]byte(fmt.Sprintf("!incr 1 counter%d", k)))
+			require.NoError(t, err)
+		}
+	}
+
+	last := make(map[string]int)
+	for i := 0; i < keys*increments; i++ {
+		key, val, ok := strings.Cut(receive(t, c), "=")
+		require.True(t, ok)
+		v, err := strconv.Atoi(val)
+		require.NoError(t, err)
+		require.Equal(t, last[key]+1, v, key)
+		last[key] = v
+	}
+}
+
+func TestPoolScanSeesEarlierWrites(t *testing.T) {
+	addr := startLocalServer(t, 8)
+	c, err := net.DialUDP("udp4", nil, addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	const keys = 50
+	for i := 0; i < keys; i++ {
+		_, err := c.Write([]byte(fmt.Sprintf("ordered/%02d=v", i)))
+		require.NoError(t, err)
+	}
+	for _, prefix := range []string{"ordered/", ""} {
+		_, err = c.Write([]byte("!keys " + prefix))
+		require.NoError(t, err)
+
+		header, body, ok := strings.Cut(receive(t, c), "\n")
+		require.True(t, ok)
+		require.Equal(t, "!keys 1/1", header)
+		require.Len(t, strings.Split(body, "\n"), keys, prefix)
+	}
+}
+
+func BenchmarkServe(b *testing.B) {
+	counts := []int{1, 2, 4}
+	if runtime.NumCPU() > 4 {
+		counts = append(counts, runtime.NumCPU())
+	}
+
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			addr := startLocalServer(b, workers)
+
+			b.RunParallel(func(pb *testing.PB) {
+				c, err := net.DialUDP("udp4", nil, addr)
+				require.NoError(b, err)
+				defer c.Close()
+
+				buffer := make([]byte, 1000)
+				i := 0
+				for pb.Next() {
+					key := fmt.Sprintf("key%d", i%64)
+					c.Write([]byte(key + "=" + strconv.Itoa(i)))
+					c.Write([]byte(key))
+					// a dropped datagram only costs the deadline
+					c.SetReadDeadline(time.Now().Add(time.Second))
+					c.ReadFromUDP(buffer)
+					i++
+				}
+			})
+		})
+	}
+}
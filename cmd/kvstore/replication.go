@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// replication datagrams carry many entries, they are exchanged only between cluster nodes
+	maxReplicationDatagram = 60000
+	replicationQueueSize   = 1024
+	// updates piled up in the queue are sent together
+	maxUpdateBatch = 32
+
+	// tombstones outlive that many sync intervals so every peer learns about the delete, but at least minTombstoneAge
+	tombstoneSyncs  = 3
+	minTombstoneAge = time.Minute
+
+	updateMessage = "update"
+	syncMessage   = "sync"
+)
+
+type clockOrder int
+
+const (
+	clockEqual clockOrder = iota
+	clockBefore
+	clockAfter
+	clockConcurrent
+)
+
+// vectorClock counts writes of a key made on every node
+type vectorClock map[string]uint64
+
+func (vc vectorClock) compare(o vectorClock) clockOrder {
+	less, greater := false, false
+	for node, c := range vc {
+		if c < o[node] {
+			less = true
+		} else if c > o[node] {
+			greater = true
+		}
+	}
+	for node, c := range o {
+		if _, ok := vc[node]; !ok && c > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return clockConcurrent
+	case less:
+		return clockBefore
+	case greater:
+		return clockAfter
+	default:
+		return clockEqual
+	}
+}
+
+func (vc vectorClock) copy() vectorClock {
+	c := make(vectorClock, len(vc)+1)
+	for node, v := range vc {
+		c[node] = v
+	}
+	return c
+}
+
+// version orders writes of a key, concurrent writes are resolved by the later wall clock time and then by node id.
+// Keys recovered from disk have no clock, they are older than any versioned write
+type version struct {
+	Clock vectorClock `json:"clock,omitempty"`
+	Time  int64       `json:"time,omitempty"`
+	Node  string      `json:"node"`
+}
+
+func (v version) newerThan(o version) bool {
+	if v.unversioned() != o.unversioned() {
+		return o.unversioned()
+	}
+
+	switch v.Clock.compare(o.Clock) {
+	case clockAfter:
+		return true
+	case clockBefore:
+		return false
+	}
+
+	if v.Time != o.Time {
+		return v.Time > o.Time
+	}
+	return v.Node > o.Node
+}
+
+func (v version) unversioned() bool {
+	return len(v.Clock) == 0
+}
+
+type replicatedEntry struct {
+	Key     string  `json:"key"`
+	Value   string  `json:"value,omitempty"`
+	Expires int64   `json:"expires,omitempty"`
+	Deleted bool    `json:"deleted,omitempty"`
+	Version version `json:"version"`
+}
+
+type replicationMessage struct {
+	Kind string `json:"kind"`
+	From string `json:"from"`
+	// set on the first datagram of a startup sync, the receiver answers with its own state
+	Reply   bool              `json:"reply,omitempty"`
+	Entries []replicatedEntry `json:"entries"`
+}
+
+type keyMeta struct {
+	version version
+	// tombstones are kept so that deletes win over older writes arriving later, until they expire
+	deleted   bool
+	deletedAt time.Time
+}
+
+func newKeyMeta(v version, deleted bool) keyMeta {
+	m := keyMeta{version: v, deleted: deleted}
+	if deleted {
+		m.deletedAt = time.Now()
+	}
+	return m
+}
+
+// Replicator propagates writes of a store to peer nodes and applies theirs with last writer wins,
+// anti-entropy sync exchanges the whole state on startup and every sync interval
+type Replicator struct {
+	id           string
+	conn         *net.UDPConn
+	peers        []*net.UDPAddr
+	store        *Store
+	syncInterval time.Duration
+
+	// guarded by the store lock, versions are kept in memory only,
+	// keys recovered from disk are unversioned until written again and lose to any versioned write
+	meta map[string]keyMeta
+
+	updates chan replicatedEntry
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewReplicator(id string, conn *net.UDPConn, peers []*net.UDPAddr, store *Store, syncInterval time.Duration) *Replicator {
+	r := &Replicator{
+		id:           id,
+		conn:         conn,
+		peers:        peers,
+		store:        store,
+		syncInterval: syncInterval,
+		meta:         make(map[string]keyMeta),
+		updates:      make(chan replicatedEntry, replicationQueueSize),
+		closed:       make(chan struct{}),
+	}
+
+	store.lock.Lock()
+	store.onWrite = r.recordWrite
+	store.lock.Unlock()
+	return r
+}
+
+func listenReplication(store *Store) (*Replicator, error) {
+	addr, err := net.ResolveUDPAddr("udp4", replicationAddr)
+	if err != nil {
+		return nil, fmt.Errorf("replication address resolution failed: %w", err)
+	}
+
+	var peerAddrs []*net.UDPAddr
+	for _, p := range strings.Split(peers, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		peerAddr, err := net.ResolveUDPAddr("udp4", p)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s resolution failed: %w", p, err)
+		}
+		peerAddrs = append(peerAddrs, peerAddr)
+	}
+
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("replication listen failed: %w", err)
+	}
+
+	id := nodeID
+	if id == "" {
+		id = replicationAddr
+	}
+
+	r := NewReplicator(id, conn, peerAddrs, store, syncInterval)
+	r.Start()
+	return r, nil
+}
+
+func (r *Replicator) Start() {
+	r.wg.Add(3)
+	go r.receive()
+	go r.sendUpdates()
+	go r.antiEntropy()
+}
+
+func (r *Replicator) Close() error {
+	r.store.lock.Lock()
+	r.store.onWrite = nil
+	r.store.lock.Unlock()
+
+	close(r.closed)
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+// recordWrite versions a local write and queues it for peers, it is called under the store lock
+func (r *Replicator) recordWrite(key string, e entry, deleted bool) {
+	clock := r.meta[key].version.Clock.copy()
+	clock[r.id]++
+	v := version{Clock: clock, Time: time.Now().UnixNano(), Node: r.id}
+	r.meta[key] = newKeyMeta(v, deleted)
+
+	select {
+	case r.updates <- replicatedEntryOf(key, e, r.meta[key]):
+	default:
+		fmt.Printf("replication queue full, %s is left to anti-entropy\n", key)
+	}
+}
+
+func replicatedEntryOf(key string, e entry, m keyMeta) replicatedEntry {
+	re := replicatedEntry{Key: key, Deleted: m.deleted, Version: m.version}
+	if !m.deleted {
+		re.Value = e.value
+		if !e.expires.IsZero() {
+			re.Expires = e.expires.UnixNano()
+		}
+	}
+	return re
+}
+
+// apply stores entries newer than the local ones without replicating them again
+func (r *Replicator) apply(entries []replicatedEntry) {
+	r.store.lock.Lock()
+	defer r.store.lock.Unlock()
+
+	for _, re := range entries {
+		// reserved for the product version, clients cannot write it either
+		if re.Key == versionKey {
+			continue
+		}
+		if !re.Version.newerThan(r.localVersion(re.Key)) {
+			continue
+		}
+
+		var err error
+		if re.Deleted {
+			if _, ok := r.store.s[re.Key]; ok {
+				err = r.store.applyRecord(walRecord{Op: walDelete, Key: re.Key})
+			}
+		} else {
+			err = r.store.applyRecord(walRecord{Op: walSet, Key: re.Key, Value: re.Value, Expires: re.Expires})
+		}
+		if err != nil {
+			fmt.Printf("failed to apply replicated %s: %v\n", re.Key, err)
+			continue
+		}
+		r.meta[re.Key] = newKeyMeta(re.Version, re.Deleted)
+	}
+}
+
+// localVersion is the version of a key, unversioned keys recovered from disk carry only the node id,
+// so recovered copies of a key on different nodes settle on the same one. It is called under the store lock
+func (r *Replicator) localVersion(key string) version {
+	if m, ok := r.meta[key]; ok {
+		return m.version
+	}
+	if _, ok := r.store.s[key]; ok {
+		return version{Node: r.id}
+	}
+	return version{}
+}
+
+// pruneTombstones forgets deletes older than the tombstone age
+func (r *Replicator) pruneTombstones(now time.Time) {
+	r.store.lock.Lock()
+	defer r.store.lock.Unlock()
+
+	for k, m := range r.meta {
+		if m.deleted && now.Sub(m.deletedAt) > r.tombstoneAge() {
+			delete(r.meta, k)
+		}
+	}
+}
+
+func (r *Replicator) tombstoneAge() time.Duration {
+	age := tombstoneSyncs * r.syncInterval
+	if age < minTombstoneAge {
+		return minTombstoneAge
+	}
+	return age
+}
+
+// state lists every key including tombstones, unversioned keys carry only the node id
+func (r *Replicator) state() []replicatedEntry {
+	r.store.lock.RLock()
+	defer r.store.lock.RUnlock()
+
+	now := time.Now()
+	entries := make([]replicatedEntry, 0, len(r.store.s))
+	for k, e := range r.store.s {
+		if e.expired(now) {
+			continue
+		}
+		m, ok := r.meta[k]
+		if !ok {
+			m = keyMeta{version: r.localVersion(k)}
+		}
+		entries = append(entries, replicatedEntryOf(k, e, m))
+	}
+	for k, m := range r.meta {
+		if m.deleted {
+			entries = append(entries, replicatedEntryOf(k, entry{}, m))
+		}
+	}
+	return entries
+}
+
+func (r *Replicator) receive() {
+	defer r.wg.Done()
+
+	buffer := make([]byte, 1<<16)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("error occured reading replication: %v\n", err)
+			continue
+		}
+		// anyone else could write keys or have the state sent to a spoofed address,
+		// dropped without logging so they cannot flood the output either
+		if !r.isPeer(addr) {
+			continue
+		}
+
+		var msg replicationMessage
+		err = json.Unmarshal(buffer[:n], &msg)
+		if err != nil {
+			fmt.Printf("invalid replication message from %s: %v\n", addr, err)
+			continue
+		}
+
+		r.apply(msg.Entries)
+		if msg.Kind == syncMessage && msg.Reply {
+			r.sendEntries([]*net.UDPAddr{addr}, syncMessage, false, r.state())
+		}
+	}
+}
+
+func (r *Replicator) isPeer(addr *net.UDPAddr) bool {
+	for _, p := range r.peers {
+		if p.IP.Equal(addr.IP) && p.Port == addr.Port {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Replicator) sendUpdates() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case re := <-r.updates:
+			batch := []replicatedEntry{re}
+			// whatever piled up meanwhile goes along, split by size
+			for len(batch) < maxUpdateBatch && len(r.updates) > 0 {
+				batch = append(batch, <-r.updates)
+			}
+			r.sendEntries(r.peers, updateMessage, false, batch)
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+func (r *Replicator) antiEntropy() {
+	defer r.wg.Done()
+
+	// peers started earlier answer with their state, peers started later ask for ours
+	r.sendEntries(r.peers, syncMessage, true, r.state())
+
+	var syncs <-chan time.Time
+	if r.syncInterval > 0 {
+		t := time.NewTicker(r.syncInterval)
+		defer t.Stop()
+		syncs = t.C
+	}
+	prune := time.NewTicker(r.tombstoneAge())
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-syncs:
+			r.sendEntries(r.peers, syncMessage, false, r.state())
+		case now := <-prune.C:
+			r.pruneTombstones(now)
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+// sendEntries splits entries into datagrams that fit maxReplicationDatagram, reply is set on the first one only
+func (r *Replicator) sendEntries(to []*net.UDPAddr, kind string, reply bool, entries []replicatedEntry) {
+	msg := replicationMessage{Kind: kind, From: r.id, Reply: reply}
+	size := 0
+	for _, re := range entries {
+		data, err := json.Marshal(re)
+		if err != nil {
+			continue
+		}
+		if size+len(data) > maxReplicationDatagram-1000 && len(msg.Entries) > 0 {
+			r.send(to, msg)
+			msg = replicationMessage{Kind: kind, From: r.id}
+			size = 0
+		}
+		msg.Entries = append(msg.Entries, re)
+		size += len(data) + 1
+	}
+	r.send(to, msg)
+}
+
+func (r *Replicator) send(to []*net.UDPAddr, msg replicationMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Printf("failed to encode replication message: %v\n", err)
+		return
+	}
+
+	for _, addr := range to {
+		_, err = r.conn.WriteToUDP(data, addr)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Printf("error occured replicating to %s: %v\n", addr, err)
+		}
+	}
+}
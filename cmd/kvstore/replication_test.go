@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testNode struct {
+	store      *Store
+	conn       *net.UDPConn
+	replConn   *net.UDPConn
+	replicator *Replicator
+}
+
+func listenLocal(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	return conn
+}
+
+// newCluster listens on localhost ports for every node, nodes replicate only once started
+func newCluster(t *testing.T, size int) []*testNode {
+	nodes := make([]*testNode, size)
+	for i := range nodes {
+		nodes[i] = &testNode{
+			store:    NewStore(),
+			conn:     listenLocal(t),
+			replConn: listenLocal(t),
+		}
+	}
+
+	for i, n := range nodes {
+		var peerAddrs []*net.UDPAddr
+		for j, p := range nodes {
+			if i != j {
+				peerAddrs = append(peerAddrs, p.replConn.LocalAddr().(*net.UDPAddr))
+			}
+		}
+		n.replicator = NewReplicator(fmt.Sprintf("node%d", i), n.replConn, peerAddrs, n.store, 0)
+	}
+
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.conn.Close()
+			n.replicator.Close()
+		}
+	})
+	return nodes
+}
+
+func (n *testNode) start() {
+	go serve(n.conn, n.store, nil, 2)
+	n.replicator.Start()
+}
+
+func (n *testNode) request(t *testing.T, req string) string {
+	c, err := net.DialUDP("udp4", nil, n.conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Write([]byte(req))
+	require.NoError(t, err)
+	return receive(t, c)
+}
+
+func (n *testNode) send(t *testing.T, req string) {
+	c, err := net.DialUDP("udp4", nil, n.conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Write([]byte(req))
+	require.NoError(t, err)
+}
+
+func (n *testNode) value(key string) string {
+	v, _ := n.store.Read(&ReadQuery{Key: key})
+	return v
+}
+
+func TestVectorClockCompare(t *testing.T) {
+	for _, tc := range []struct {
+		a, b vectorClock
+		exp  clockOrder
+	}{
+		{a: nil, b: vectorClock{}, exp: clockEqual},
+		{a: vectorClock{"a": 1}, b: vectorClock{"a": 1}, exp: clockEqual},
+		{a: vectorClock{"a": 1}, b: vectorClock{"a": 2}, exp: clockBefore},
+		{a: vectorClock{"a": 1}, b: nil, exp: clockAfter},
+		{a: vectorClock{"a": 1}, b: vectorClock{"a": 1, "b": 1}, exp: clockBefore},
+		{a: vectorClock{"a": 2}, b: vectorClock{"a": 1, "b": 1}, exp: clockConcurrent},
+	} {
+		t.Run(fmt.Sprintf("%v %v", tc.a, tc.b), func(t *testing.T) {
+			require.Equal(t, tc.exp, tc.a.compare(tc.b))
+		})
+	}
+
+	older := version{Clock: vectorClock{"a": 2}, Time: 100, Node: "a"}
+	newer := version{Clock: vectorClock{"a": 1, "b": 1}, Time: 200, Node: "b"}
+	require.True(t, newer.newerThan(older))
+	require.False(t, older.newerThan(newer))
+	require.False(t, newer.newerThan(newer))
+	require.True(t, newer.newerThan(version{}))
+
+	// keys recovered from disk lose to any versioned write and settle by node id among themselves
+	recovered := version{Node: "z"}
+	require.True(t, older.newerThan(recovered))
+	require.False(t, recovered.newerThan(older))
+	require.True(t, recovered.newerThan(version{Node: "a"}))
+}
+
+func TestReplicationPropagatesWrites(t *testing.T) {
+	nodes := newCluster(t, 3)
+	for _, n := range nodes {
+		n.start()
+	}
+
+	nodes[0].send(t, "foo=bar")
+	nodes[1].send(t, "!setex 1h session=abc")
+	nodes[2].send(t, "gone=soon")
+
+	for _, n := range nodes {
+		require.Eventually(t, func() bool {
+			return n.request(t, "foo") == "foo=bar" && n.request(t, "session") == "session=abc" && n.value("gone") == "soon"
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+
+	nodes[1].send(t, "!del gone")
+	for _, n := range nodes {
+		require.Eventually(t, func() bool {
+			return n.request(t, "gone") == "gone="
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+
+	// counters replicate their resulting values
+	require.Equal(t, "hits=1", nodes[0].request(t, "!incr 1 hits"))
+	require.Eventually(t, func() bool { return nodes[2].value("hits") == "1" }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, "hits=2", nodes[2].request(t, "!incr 1 hits"))
+	require.Eventually(t, func() bool { return nodes[0].value("hits") == "2" }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReplicationConvergesOnConflicts(t *testing.T) {
+	nodes := newCluster(t, 3)
+	for _, n := range nodes {
+		n.start()
+	}
+
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *testNode) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				n.store.Insert(&InsertCmd{Key: "contended", Value: fmt.Sprintf("node%d-%d", i, j)})
+			}
+		}(i, n)
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		v := nodes[0].value("contended")
+		return v != "" && nodes[1].value("contended") == v && nodes[2].value("contended") == v
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReplicationSyncsOnStartup(t *testing.T) {
+	nodes := newCluster(t, 3)
+	nodes[0].start()
+	nodes[1].start()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, nodes[i%2].store.Insert(&InsertCmd{Key: fmt.Sprintf("key%d", i), Value: strconv.Itoa(i)}))
+	}
+	require.Eventually(t, func() bool { return nodes[0].value("key99") == "99" }, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, nodes[0].store.Delete(&DeleteCmd{Key: "key1"}))
+	require.Eventually(t, func() bool { return nodes[1].value("key1") == "" }, 2*time.Second, 10*time.Millisecond)
+
+	// written before the node joined the cluster
+	require.NoError(t, nodes[2].store.Insert(&InsertCmd{Key: "offline", Value: "node2"}))
+
+	nodes[2].start()
+	require.Eventually(t, func() bool {
+		for i := 0; i < 100; i++ {
+			exp := strconv.Itoa(i)
+			if i == 1 {
+				exp = ""
+			}
+			if nodes[2].value(fmt.Sprintf("key%d", i)) != exp {
+				return false
+			}
+		}
+		return nodes[0].value("offline") == "node2" && nodes[1].value("offline") == "node2"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReplicationConvergesOnRecoveredKeys(t *testing.T) {
+	nodes := newCluster(t, 2)
+	// recovered from disk, without versions
+	for i, n := range nodes {
+		n.store.s["recovered"] = entry{value: fmt.Sprintf("node%d", i)}
+		n.store.s["stale"] = entry{value: "recovered"}
+	}
+	require.NoError(t, nodes[0].store.Insert(&InsertCmd{Key: "stale", Value: "written"}))
+
+	for _, n := range nodes {
+		n.start()
+	}
+	for _, n := range nodes {
+		require.Eventually(t, func() bool {
+			return n.value("recovered") == "node1" && n.value("stale") == "written"
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestReplicationPrunesTombstones(t *testing.T) {
+	nodes := newCluster(t, 1)
+	n := nodes[0]
+	require.NoError(t, n.store.Insert(&InsertCmd{Key: "kept", Value: "v"}))
+	require.NoError(t, n.store.Insert(&InsertCmd{Key: "gone", Value: "v"}))
+	require.NoError(t, n.store.Delete(&DeleteCmd{Key: "gone"}))
+
+	r := n.replicator
+	r.pruneTombstones(time.Now())
+	require.Contains(t, r.meta, "gone")
+
+	r.pruneTombstones(time.Now().Add(r.tombstoneAge() + time.Second))
+	require.NotContains(t, r.meta, "gone")
+	require.Contains(t, r.meta, "kept")
+}
+
+func TestReplicationSplitsLargeBatches(t *testing.T) {
+	nodes := newCluster(t, 1)
+	peer := listenLocal(t)
+	defer peer.Close()
+
+	var entries []replicatedEntry
+	for i := 0; i < maxUpdateBatch; i++ {
+		entries = append(entries, replicatedEntry{Key: fmt.Sprintf("key%d", i), Value: strings.Repeat("v", 990)})
+	}
+	nodes[0].replicator.sendEntries([]*net.UDPAddr{peer.LocalAddr().(*net.UDPAddr)}, updateMessage, false, entries)
+	nodes[0].replicator.sendEntries([]*net.UDPAddr{peer.LocalAddr().(*net.UDPAddr)}, updateMessage, false, append(entries, entries...))
+
+	buffer := make([]byte, 1<<17)
+	received, datagrams := 0, 0
+	for received < 3*maxUpdateBatch {
+		peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := peer.ReadFromUDP(buffer)
+		require.NoError(t, err)
+		require.LessOrEqual(t, n, maxReplicationDatagram)
+
+		var msg replicationMessage
+		require.NoError(t, json.Unmarshal(buffer[:n], &msg))
+		received += len(msg.Entries)
+		datagrams++
+	}
+	require.Equal(t, 3*maxUpdateBatch, received)
+	require.Greater(t, datagrams, 2)
+}
+
+func TestReplicationAcceptsOnlyPeers(t *testing.T) {
+	n := newCluster(t, 1)[0]
+	peer := listenLocal(t)
+	defer peer.Close()
+	stranger := listenLocal(t)
+	defer stranger.Close()
+	n.replicator.peers = []*net.UDPAddr{peer.LocalAddr().(*net.UDPAddr)}
+	n.start()
+	to := n.replConn.LocalAddr().(*net.UDPAddr)
+
+	v := version{Clock: vectorClock{"other": 1}, Node: "other"}
+	send := func(c *net.UDPConn, msg replicationMessage) {
+		payload, err := json.Marshal(msg)
+		require.NoError(t, err)
+		_, err = c.WriteToUDP(payload, to)
+		require.NoError(t, err)
+	}
+	send(stranger, replicationMessage{Kind: updateMessage, Entries: []replicatedEntry{{Key: "stranger", Value: "v", Version: v}}})
+	send(stranger, replicationMessage{Kind: syncMessage, Reply: true})
+	send(peer, replicationMessage{Kind: updateMessage, Entries: []replicatedEntry{
+		{Key: versionKey, Value: "hacked", Version: v},
+		{Key: "peer", Value: "v", Version: v},
+	}})
+
+	require.Eventually(t, func() bool { return n.value("peer") == "v" }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, "", n.value("stranger"))
+	_, err := n.store.Read(&ReadQuery{Key: versionKey})
+	require.Equal(t, errNotFound, err)
+
+	// sync requests of strangers are not answered
+	stranger.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = stranger.ReadFromUDP(make([]byte, 1<<16))
+	require.Error(t, err)
+}
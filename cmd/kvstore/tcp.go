@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// escaping can double the length of a request
+	maxLineSize    = 2*maxDatagramSize + 2
+	tcpIdleTimeout = 120 * time.Second
+)
+
+// new lines inside requests and responses are escaped as `\n`, backslashes as `\\`
+var lineEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func unescapeLine(line string) string {
+	if !strings.Contains(line, `\`) {
+		return line
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) {
+			switch line[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(line[i])
+	}
+	return b.String()
+}
+
+// serveTCP answers requests sent one per line, each response is written on its own line
+func serveTCP(c net.Conn, store *Store, limiter *rateLimiter) {
+	defer c.Close()
+
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(make([]byte, 0, 1024), maxLineSize)
+	w := bufio.NewWriter(c)
+
+	c.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+	for scanner.Scan() {
+		c.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+
+		req := unescapeLine(strings.TrimSuffix(scanner.Text(), "\r"))
+		if len(req) > maxDatagramSize {
+			w.WriteString("!err request too long\n")
+			w.Flush()
+			continue
+		}
+		if !limiter.allow(c.RemoteAddr(), time.Now()) {
+			// unlike datagrams a dropped request would leave the client waiting for its response
+			w.WriteString("!err rate limited\n")
+			w.Flush()
+			continue
+		}
+
+		for _, resp := range execute(getAction(req), store) {
+			w.WriteString(lineEscaper.Replace(resp) + "\n")
+		}
+		err := w.Flush()
+		if err != nil {
+			fmt.Printf("error occured writing: %v\n", err)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("closing tcp connection from %s: %v\n", c.RemoteAddr(), err)
+	}
+}
+
+func startTCPServer(addr string, store *Store, limiter *rateLimiter) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("tcp listener failed: %v", err)
+		return
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go serveTCP(conn, store, limiter)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func dialTCP(t *testing.T) (net.Conn, *bufio.Reader) {
+	var c net.Conn
+	require.Eventually(t, func() bool {
+		var err error
+		c, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", serverPort))
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	return c, bufio.NewReader(c)
+}
+
+func readResponse(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestTCP(t *testing.T) {
+	c, r := dialTCP(t)
+
+	fmt.Fprint(c, "tcp1=foo=bar\ntcp1\n")
+	require.Equal(t, "tcp1=foo=bar", readResponse(t, r))
+
+	fmt.Fprint(c, "version\r\n")
+	require.Equal(t, fmt.Sprintf("version=%s", ProductVersion), readResponse(t, r))
+
+	// new lines are escaped both ways
+	fmt.Fprint(c, "!del tcp2\n")
+	fmt.Fprint(c, `!cas tcp2\n\nmulti\nline \\n`+"\n")
+	require.Equal(t, `!cas ok tcp2=multi\nline \\n`, readResponse(t, r))
+
+	fmt.Fprint(c, "!keys tcp\n")
+	require.Equal(t, `!keys 1/1\ntcp1\ntcp2`, readResponse(t, r))
+
+	fmt.Fprintf(c, "tcp3=%s\n", strings.Repeat("x", maxDatagramSize))
+	require.Equal(t, "!err request too long", readResponse(t, r))
+}
+
+func TestTransportsShareStore(t *testing.T) {
+	c, r := dialTCP(t)
+	u := dialStore(t)
+
+	_, err := u.Write([]byte("shared=udp"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		fmt.Fprint(c, "shared\n")
+		return readResponse(t, r) == "shared=udp"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	fmt.Fprint(c, "shared=tcp\n")
+	require.Eventually(t, func() bool {
+		return httpGet(t, "shared") == "tcp"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	httpDo(t, "PUT", "shared", "http")
+	_, err = u.Write([]byte("shared"))
+	require.NoError(t, err)
+	require.Equal(t, "shared=http", receive(t, u))
+}
+
+func TestTCPRateLimited(t *testing.T) {
+	server, c := net.Pipe()
+	defer c.Close()
+	go serveTCP(server, NewStore(), newRateLimiter(0.001, 1))
+	r := bufio.NewReader(c)
+
+	fmt.Fprint(c, "limited=1\n")
+	fmt.Fprint(c, "limited\n")
+	require.Equal(t, "!err rate limited", readResponse(t, r))
+}
+
+func TestUnescapeLine(t *testing.T) {
+	for _, s := range []string{"", "plain", "a\nb", `back\slash`, "\\\n\\n", `trailing\`} {
+		require.Equal(t, s, unescapeLine(lineEscaper.Replace(s)))
+	}
+	require.Equal(t, `\x`, unescapeLine(`\x`))
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.db"
+
+	// record header is crc32 of the payload followed by the payload length
+	walHeaderSize = 8
+	// anything longer is a corrupted length, requests are shorter than 1000 bytes
+	walMaxRecordSize = 1 << 16
+)
+
+var (
+	errCorruptRecord = errors.New("corrupt record")
+)
+
+type walOp byte
+
+const (
+	walSet walOp = iota + 1
+	walDelete
+)
+
+// walRecord is a single mutation, records carry resulting values so replaying them twice is harmless
+type walRecord struct {
+	Op    walOp
+	Key   string
+	Value string
+	// unix nanoseconds, zero when the key never expires
+	Expires int64
+}
+
+// SyncPolicy decides when appended records are flushed to disk
+type SyncPolicy string
+
+var (
+	SyncAlways   SyncPolicy = "always"
+	SyncInterval SyncPolicy = "interval"
+	SyncNever    SyncPolicy = "never"
+)
+
+func (p *SyncPolicy) String() string {
+	return string(*p)
+}
+
+func (p *SyncPolicy) Set(v string) error {
+	switch SyncPolicy(v) {
+	case SyncAlways, SyncInterval, SyncNever:
+		*p = SyncPolicy(v)
+		return nil
+	default:
+		return fmt.Errorf("unknown fsync policy %q (always, interval, never)", v)
+	}
+}
+
+type DurabilityOptions struct {
+	Sync             SyncPolicy
+	SyncInterval     time.Duration
+	SnapshotInterval time.Duration
+}
+
+func encodeRecord(r walRecord) []byte {
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(r.Key)+len(r.Value))
+	payload = append(payload, byte(r.Op))
+	payload = appendUvarint(payload, uint64(len(r.Key)))
+	payload = append(payload, r.Key...)
+	payload = appendUvarint(payload, uint64(len(r.Value)))
+	payload = append(payload, r.Value...)
+	if r.Expires != 0 {
+		payload = appendUvarint(payload, uint64(r.Expires))
+	}
+
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return append(b, buf[:n]...)
+}
+
+// readRecord returns io.EOF at clean end of the log and io.ErrUnexpectedEOF or errCorruptRecord for a torn tail
+func readRecord(r io.Reader) (walRecord, int, error) {
+	header := make([]byte, walHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		if err == io.EOF {
+			return walRecord{}, 0, io.EOF
+		}
+		return walRecord{}, n, io.ErrUnexpectedEOF
+	}
+
+	checksum := binary.BigEndian.Uint32(header[0:4])
+	size := binary.BigEndian.Uint32(header[4:8])
+	if size > walMaxRecordSize {
+		return walRecord{}, n, errCorruptRecord
+	}
+	payload := make([]byte, size)
+	m, err := io.ReadFull(r, payload)
+	if err != nil {
+		return walRecord{}, n + m, io.ErrUnexpectedEOF
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return walRecord{}, n + m, errCorruptRecord
+	}
+
+	rec, err := decodePayload(payload)
+	return rec, n + m, err
+}
+
+func decodePayload(payload []byte) (walRecord, error) {
+	if len(payload) == 0 {
+		return walRecord{}, errCorruptRecord
+	}
+	rec := walRecord{Op: walOp(payload[0])}
+	rest := payload[1:]
+
+	fields := make([]string, 2)
+	for i := range fields {
+		l, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < l {
+			return walRecord{}, errCorruptRecord
+		}
+		fields[i] = string(rest[n : n+int(l)])
+		rest = rest[n+int(l):]
+	}
+	rec.Key, rec.Value = fields[0], fields[1]
+
+	// expiry is optional, records of keys without one end after the value
+	if len(rest) > 0 {
+		expires, n := binary.Uvarint(rest)
+		if n <= 0 || n != len(rest) {
+			return walRecord{}, errCorruptRecord
+		}
+		rec.Expires = int64(expires)
+	}
+	return rec, nil
+}
+
+func setRecord(key string, e entry) walRecord {
+	rec := walRecord{Op: walSet, Key: key, Value: e.value}
+	if !e.expires.IsZero() {
+		rec.Expires = e.expires.UnixNano()
+	}
+	return rec
+}
+
+func (s storage) apply(r walRecord) {
+	switch r.Op {
+	case walSet:
+		e := entry{value: r.Value}
+		if r.Expires != 0 {
+			e.expires = time.Unix(0, r.Expires)
+		}
+		s[r.Key] = e
+	case walDelete:
+		delete(s, r.Key)
+	}
+}
+
+// writeAheadLog appends every mutation before it is applied to the in-memory storage
+type writeAheadLog struct {
+	dir  string
+	f    *os.File
+	w    *bufio.Writer
+	opts DurabilityOptions
+
+	// guards file handle against the background sync, mutations are serialized by the store lock
+	lock   sync.Mutex
+	dirty  bool
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// OpenStore recovers the store from the last snapshot and the log written after it
+func OpenStore(dir string, opts DurabilityOptions) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %w", err)
+	}
+
+	s := NewStore()
+	err = loadSnapshot(filepath.Join(dir, snapshotFileName), s.s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	walPath := filepath.Join(dir, walFileName)
+	err = replayLog(walPath, s.s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay log: %w", err)
+	}
+
+	f, err := os.OpenFile(walPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log: %w", err)
+	}
+
+	s.wal = &writeAheadLog{
+		dir:    dir,
+		f:      f,
+		w:      bufio.NewWriter(f),
+		opts:   opts,
+		closed: make(chan struct{}),
+	}
+	s.wal.start(s)
+	return s, nil
+}
+
+func loadSnapshot(path string, s storage) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		rec, _, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// snapshots are renamed into place only once complete
+			return fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		s.apply(rec)
+	}
+}
+
+// replayLog applies complete records and cuts off a torn record left by a crash in the middle of a write
+func replayLog(path string, s storage) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	valid := int64(0)
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("truncating log %s at %d: %v\n", path, valid, err)
+			return f.Truncate(valid)
+		}
+		s.apply(rec)
+		valid += int64(n)
+	}
+}
+
+func (w *writeAheadLog) start(s *Store) {
+	if w.opts.Sync == SyncInterval && w.opts.SyncInterval > 0 {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			t := time.NewTicker(w.opts.SyncInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := w.sync(); err != nil {
+						fmt.Printf("log sync failed: %v\n", err)
+					}
+				case <-w.closed:
+					return
+				}
+			}
+		}()
+	}
+
+	if w.opts.SnapshotInterval > 0 {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			t := time.NewTicker(w.opts.SnapshotInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := s.Snapshot(); err != nil {
+						fmt.Printf("snapshot failed: %v\n", err)
+					}
+				case <-w.closed:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (w *writeAheadLog) append(r walRecord) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_, err := w.w.Write(encodeRecord(r))
+	if err != nil {
+		return err
+	}
+
+	switch w.opts.Sync {
+	case SyncAlways:
+		return w.flush(true)
+	case SyncNever:
+		return w.flush(false)
+	default:
+		w.dirty = true
+		return w.flush(false)
+	}
+}
+
+func (w *writeAheadLog) flush(fsync bool) error {
+	err := w.w.Flush()
+	if err != nil {
+		return err
+	}
+	if fsync {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+func (w *writeAheadLog) sync() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.flush(true)
+}
+
+// snapshot writes the whole storage next to the log and swaps it in, the log is emptied afterwards,
+// crashing in between is safe as records replayed over the newer snapshot yield the same values
+func (w *writeAheadLog) snapshot(s storage) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	tmpPath := filepath.Join(w.dir, snapshotFileName+".tmp")
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	bw := bufio.NewWriter(f)
+	for k, e := range s {
+		if e.expired(now) {
+			delete(s, k)
+			continue
+		}
+		_, err = bw.Write(encodeRecord(setRecord(k, e)))
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = bw.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(w.dir, snapshotFileName))
+	if err != nil {
+		return err
+	}
+	err = syncDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	err = w.flush(false)
+	if err != nil {
+		return err
+	}
+	err = w.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (w *writeAheadLog) close() error {
+	close(w.closed)
+	w.wg.Wait()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err := w.flush(w.opts.Sync != SyncNever)
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Snapshot persists the current state and compacts the log, it is a no-op for in-memory stores
+func (s *Store) Snapshot() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.wal.snapshot(s.s)
+}
+
+func (s *Store) Close() error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.close()
+}
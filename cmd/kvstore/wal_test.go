@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testDurability = DurabilityOptions{Sync: SyncAlways}
+
+func insert(t *testing.T, s *Store, key string, value string) {
+	require.NoError(t, s.Insert(&InsertCmd{Key: key, Value: value}))
+}
+
+func read(t *testing.T, s *Store, key string) string {
+	v, err := s.Read(&ReadQuery{Key: key})
+	require.NoError(t, err)
+	return v
+}
+
+func TestRecordEncoding(t *testing.T) {
+	rec := walRecord{Op: walSet, Key: "foo", Value: "bar=baz\n"}
+	enc := encodeRecord(rec)
+
+	dec, n, err := readRecord(bytes.NewReader(enc))
+	require.NoError(t, err)
+	require.Equal(t, len(enc), n)
+	require.Equal(t, rec, dec)
+
+	enc[len(enc)-1] ^= 0xff
+	_, _, err = readRecord(bytes.NewReader(enc))
+	require.Equal(t, errCorruptRecord, err)
+}
+
+func TestStoreRecoversFromLog(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	insert(t, s, "foo", "bar")
+	insert(t, s, "", "empty key")
+	insert(t, s, "foo", "baz")
+	// killed, never closed
+
+	s, err = OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, "baz", read(t, s, "foo"))
+	require.Equal(t, "empty key", read(t, s, ""))
+}
+
+func TestStoreDiscardsTornWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	insert(t, s, "foo", "bar")
+	require.NoError(t, s.Close())
+
+	// crashed in the middle of writing the next record
+	torn := encodeRecord(walRecord{Op: walSet, Key: "foo", Value: "lost"})
+	f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(torn[:len(torn)-2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	require.Equal(t, "bar", read(t, s, "foo"))
+	insert(t, s, "next", "value")
+	require.NoError(t, s.Close())
+
+	s, err = OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, "bar", read(t, s, "foo"))
+	require.Equal(t, "value", read(t, s, "next"))
+}
+
+func TestStoreSnapshotCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		insert(t, s, fmt.Sprintf("key%d", i%10), strconv.Itoa(i))
+	}
+	require.NoError(t, s.Snapshot())
+
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Size())
+
+	insert(t, s, "key0", "after snapshot")
+	// crashed while writing another snapshot
+	require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFileName+".tmp"), []byte("garbage"), 0644))
+
+	s, err = OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, "after snapshot", read(t, s, "key0"))
+	require.Equal(t, "99", read(t, s, "key9"))
+}
+
+// TestStoreHelperProcess is run by TestStoreSurvivesKill as a separate process writing until killed
+func TestStoreHelperProcess(t *testing.T) {
+	dir := os.Getenv("KVSTORE_HELPER_DIR")
+	if dir == "" {
+		t.Skip("helper process")
+	}
+
+	s, err := OpenStore(dir, DurabilityOptions{Sync: SyncAlways, SnapshotInterval: 20 * time.Millisecond})
+	require.NoError(t, err)
+	for i := 0; ; i++ {
+		insert(t, s, fmt.Sprintf("key%d", i), strconv.Itoa(i))
+		insert(t, s, "last", strconv.Itoa(i))
+		fmt.Printf("ack %d\n", i)
+	}
+}
+
+func TestStoreSurvivesKill(t *testing.T) {
+	dir := t.TempDir()
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestStoreHelperProcess")
+	cmd.Env = append(os.Environ(), "KVSTORE_HELPER_DIR="+dir)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+
+	acked := -1
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var i int
+		if _, err := fmt.Sscanf(scanner.Text(), "ack %d", &i); err == nil {
+			acked = i
+		}
+		if acked >= 500 {
+			break
+		}
+	}
+	require.NoError(t, cmd.Process.Kill())
+	cmd.Wait()
+	require.GreaterOrEqual(t, acked, 500)
+
+	s, err := OpenStore(dir, testDurability)
+	require.NoError(t, err)
+	defer s.Close()
+
+	last, err := strconv.Atoi(read(t, s, "last"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, last, acked)
+	for i := 0; i <= last; i++ {
+		require.Equal(t, strconv.Itoa(i), read(t, s, fmt.Sprintf("key%d", i)))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionRegistry tracks running sessions so they can be listed and terminated over the admin api
+type sessionRegistry struct {
+	lock     sync.Mutex
+	sessions map[uint64]*session
+	nextID   uint64
+}
+
+type sessionInfo struct {
+	ID              uint64    `json:"id"`
+	Mode            string    `json:"mode"`
+	Source          string    `json:"source"`
+	Upstream        string    `json:"upstream"`
+	Started         time.Time `json:"started"`
+	UpstreamBytes   int64     `json:"upstream_bytes"`
+	DownstreamBytes int64     `json:"downstream_bytes"`
+	Rewrites        int64     `json:"rewrites"`
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: map[uint64]*session{}}
+}
+
+// add assigns the session its id
+func (r *sessionRegistry) add(s *session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nextID++
+	s.id = r.nextID
+	r.sessions[s.id] = s
+}
+
+func (r *sessionRegistry) remove(s *session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.sessions, s.id)
+}
+
+// list returns running sessions ordered by id
+func (r *sessionRegistry) list() []sessionInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	infos := make([]sessionInfo, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		infos = append(infos, sessionInfo{
+			ID:              s.id,
+			Mode:            s.mode.String(),
+			Source:          s.source,
+			Upstream:        s.upstream,
+			Started:         s.started,
+			UpstreamBytes:   atomic.LoadInt64(&s.upstreamBytes),
+			DownstreamBytes: atomic.LoadInt64(&s.downstreamBytes),
+			Rewrites:        atomic.LoadInt64(&s.rewrites),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// terminate stops the session, it reports false when there is no such session
+func (r *sessionRegistry) terminate(id uint64) bool {
+	r.lock.Lock()
+	s, ok := r.sessions[id]
+	r.lock.Unlock()
+	if ok {
+		s.stop()
+	}
+	return ok
+}
+
+// newAdminHandler serves `GET /sessions` listing running sessions as JSON and `DELETE /sessions/{id}` terminating one
+func newAdminHandler(reg *sessionRegistry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.list())
+	})
+	mux.HandleFunc("/sessions/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodDelete {
+			w.Header().Set("Allow", "DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseUint(strings.TrimPrefix(req.URL.Path, "/sessions/"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid session id", http.StatusBadRequest)
+			return
+		}
+		if !reg.terminate(id) {
+			http.Error(w, fmt.Sprintf("session %d not found", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func findSession(t *testing.T, admin string, source string) (sessionInfo, bool) {
+	resp, err := http.Get(admin + "/sessions")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var infos []sessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&infos))
+	for _, info := range infos {
+		if info.Source == source {
+			return info, true
+		}
+	}
+	return sessionInfo{}, false
+}
+
+func deleteSession(t *testing.T, admin string, id string) int {
+	req, err := http.NewRequest(http.MethodDelete, admin+"/sessions/"+id, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminSessions(t *testing.T) {
+	upstream := startLineServers(t, "echo")
+	addr := startProxyListener(t, lineMode, upstream...)
+	admin := httptest.NewServer(newAdminHandler(sessions))
+	t.Cleanup(admin.Close)
+
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c.Close()
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "echo\n", line)
+	fmt.Fprint(c, "pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n")
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n", line)
+
+	info, ok := findSession(t, admin.URL, c.LocalAddr().String())
+	require.True(t, ok)
+	require.Equal(t, "line", info.Mode)
+	require.Equal(t, upstream[0], info.Upstream)
+	require.WithinDuration(t, time.Now(), info.Started, 5*time.Second)
+	// the rewritten address goes up and comes back rewritten again
+	require.Equal(t, int64(len("pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n")), info.UpstreamBytes)
+	require.Equal(t, int64(len("echo\npay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n")), info.DownstreamBytes)
+	require.Equal(t, int64(2), info.Rewrites)
+
+	id := fmt.Sprint(info.ID)
+	require.Equal(t, http.StatusNoContent, deleteSession(t, admin.URL, id))
+	_, err = io.ReadAll(r)
+	require.NoError(t, err, "terminated session closes the client connection")
+
+	// the session is removed once its goroutines exited
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, ok = findSession(t, admin.URL, c.LocalAddr().String())
+		if !ok || time.Now().After(deadline) {
+			break
+		}
+	}
+	require.False(t, ok)
+	require.Equal(t, http.StatusNotFound, deleteSession(t, admin.URL, id))
+	require.Equal(t, http.StatusBadRequest, deleteSession(t, admin.URL, "abc"))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// connectHandshake reads an HTTP CONNECT request, it returns the requested address
+func connectHandshake(r *bufio.Reader, w io.Writer, auth credentials) (string, error) {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		writeConnectStatus(w, http.StatusBadRequest, "")
+		return "", fmt.Errorf("failed to read request: %w", err)
+	}
+	req.Body.Close()
+
+	if req.Method != http.MethodConnect {
+		writeConnectStatus(w, http.StatusMethodNotAllowed, "Allow: CONNECT\r\n")
+		return "", fmt.Errorf("unsupported method %s", req.Method)
+	}
+	if auth.required() {
+		user, password, ok := proxyBasicAuth(req)
+		if !ok || !auth.valid(user, password) {
+			writeConnectStatus(w, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"proxy\"\r\n")
+			return "", errTunnelAuth
+		}
+	}
+
+	_, _, err = net.SplitHostPort(req.Host)
+	if err != nil {
+		writeConnectStatus(w, http.StatusBadRequest, "")
+		return "", fmt.Errorf("invalid target %q: %w", req.Host, err)
+	}
+	return req.Host, nil
+}
+
+// connectReply tells the client whether connecting the upstream succeeded
+func connectReply(w io.Writer, dialErr error) error {
+	if dialErr == errTunnelNotAllowed {
+		return writeConnectStatus(w, http.StatusForbidden, "")
+	}
+	if dialErr != nil {
+		return writeConnectStatus(w, http.StatusBadGateway, "")
+	}
+	return writeConnectStatus(w, http.StatusOK, "")
+}
+
+func writeConnectStatus(w io.Writer, code int, header string) error {
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n%s\r\n", code, http.StatusText(code), header)
+	return err
+}
+
+// proxyBasicAuth reads credentials of the Proxy-Authorization header the way BasicAuth reads Authorization
+func proxyBasicAuth(req *http.Request) (string, string, bool) {
+	r := &http.Request{Header: http.Header{"Authorization": req.Header.Values("Proxy-Authorization")}}
+	return r.BasicAuth()
+}
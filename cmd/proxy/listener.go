@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// proxyMode decides how traffic of a listener is framed
+type proxyMode int
+
+const (
+	// lineMode forwards complete lines passing them through the rewrite rules
+	lineMode proxyMode = iota
+	// rawMode copies bytes as they come, it is suitable for binary protocols and is never rewritten
+	rawMode
+)
+
+func (m proxyMode) String() string {
+	switch m {
+	case lineMode:
+		return "line"
+	case rawMode:
+		return "raw"
+	default:
+		return fmt.Sprintf("proxyMode(%d)", int(m))
+	}
+}
+
+func parseProxyMode(v string) (proxyMode, error) {
+	switch v {
+	case "line":
+		return lineMode, nil
+	case "raw":
+		return rawMode, nil
+	default:
+		return lineMode, fmt.Errorf("unknown proxy mode %q (line, raw)", v)
+	}
+}
+
+// frontEnd decides how a listener picks the upstream of a session
+type frontEnd int
+
+const (
+	// direct sends sessions to the upstreams of the listener
+	direct frontEnd = iota
+	// socks5 and httpConnect let clients choose the upstream in a handshake
+	socks5
+	httpConnect
+)
+
+func (f frontEnd) String() string {
+	switch f {
+	case direct:
+		return "direct"
+	case socks5:
+		return "socks5"
+	case httpConnect:
+		return "connect"
+	default:
+		return fmt.Sprintf("frontEnd(%d)", int(f))
+	}
+}
+
+// parseListenerMode reads `line`, `raw` or a front end optionally followed by a mode, e.g. `socks5+raw`,
+// sessions of front ends are line ones by default
+func parseListenerMode(v string) (frontEnd, proxyMode, error) {
+	name, modeName, withMode := strings.Cut(v, "+")
+
+	front := direct
+	switch name {
+	case "socks5":
+		front = socks5
+	case "connect":
+		front = httpConnect
+	default:
+		if withMode {
+			return front, lineMode, fmt.Errorf("unknown front end %q (socks5, connect)", name)
+		}
+		mode, err := parseProxyMode(name)
+		return front, mode, err
+	}
+
+	if !withMode {
+		return front, lineMode, nil
+	}
+	mode, err := parseProxyMode(modeName)
+	return front, mode, err
+}
+
+type listenerConfig struct {
+	front frontEnd
+	mode  proxyMode
+	addr  string
+	// empty when sessions go to the upstreams shared by listeners
+	upstreams []string
+}
+
+// parseListener reads `[[front+]mode@]addr[=upstream,upstream]`
+func parseListener(v string) (listenerConfig, error) {
+	cfg := listenerConfig{mode: lineMode}
+
+	if mode, rest, ok := strings.Cut(v, "@"); ok {
+		var err error
+		cfg.front, cfg.mode, err = parseListenerMode(mode)
+		if err != nil {
+			return cfg, err
+		}
+		v = rest
+	}
+
+	addr, upstreamList, ok := strings.Cut(v, "=")
+	if addr == "" {
+		return cfg, fmt.Errorf("listener %q has no address", v)
+	}
+	cfg.addr = addr
+	if ok {
+		for _, u := range strings.Split(upstreamList, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				cfg.upstreams = append(cfg.upstreams, u)
+			}
+		}
+	}
+	if cfg.front != direct && len(cfg.upstreams) > 0 {
+		return cfg, fmt.Errorf("listener %q: %s clients choose their upstreams", v, cfg.front)
+	}
+	return cfg, nil
+}
+
+// listenerFlags collects repeated -listen flags
+type listenerFlags []listenerConfig
+
+func (f *listenerFlags) String() string {
+	var specs []string
+	for _, l := range *f {
+		spec := fmt.Sprintf("%s@%s", l.mode, l.addr)
+		if l.front != direct {
+			spec = fmt.Sprintf("%s+%s", l.front, spec)
+		}
+		if len(l.upstreams) > 0 {
+			spec += "=" + strings.Join(l.upstreams, ",")
+		}
+		specs = append(specs, spec)
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f *listenerFlags) Set(v string) error {
+	cfg, err := parseListener(v)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, cfg)
+	return nil
+}
+
+type proxyListener struct {
+	front     frontEnd
+	mode      proxyMode
+	upstreams *upstreamPool
+}
+
+func (pl *proxyListener) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		conn.SetDeadline(time.Now().Add(time.Second * 120 * 10)) //TODO: remove 10
+		if pl.front == direct {
+			go handleConnection(conn, pl.upstreams, pl.mode)
+		} else {
+			go handleTunnel(conn, pl.front, pl.mode)
+		}
+	}
+}
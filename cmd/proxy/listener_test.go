@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListener(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		exp  listenerConfig
+		err  bool
+	}{
+		{spec: ":8887", exp: listenerConfig{mode: lineMode, addr: ":8887"}},
+		{spec: "raw@:9000", exp: listenerConfig{mode: rawMode, addr: ":9000"}},
+		{spec: "line@localhost:9000=a:1, b:2", exp: listenerConfig{mode: lineMode, addr: "localhost:9000", upstreams: []string{"a:1", "b:2"}}},
+		{spec: "raw@[::1]:9000=[::1]:10000", exp: listenerConfig{mode: rawMode, addr: "[::1]:9000", upstreams: []string{"[::1]:10000"}}},
+		{spec: "socks5@:1080", exp: listenerConfig{front: socks5, mode: lineMode, addr: ":1080"}},
+		{spec: "connect+raw@:8080", exp: listenerConfig{front: httpConnect, mode: rawMode, addr: ":8080"}},
+		{spec: "binary@:9000", err: true},
+		{spec: "socks4+line@:1080", err: true},
+		{spec: "socks5+binary@:1080", err: true},
+		{spec: "socks5@:1080=a:1", err: true},
+		{spec: "raw@=a:1", err: true},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			cfg, err := parseListener(tc.spec)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.exp, cfg)
+		})
+	}
+}
+
+// startDrainServer echoes everything it receives once the client is done sending and closes afterwards
+func startDrainServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				data, err := io.ReadAll(c)
+				if err != nil {
+					return
+				}
+				c.Write(append(data, "bye"...))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func startProxyListener(t *testing.T, mode proxyMode, upstreams ...string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	pl := &proxyListener{mode: mode, upstreams: newUpstreamPool(upstreams, testUpstreamOpts)}
+	go pl.serve(l)
+	return l.Addr().String()
+}
+
+func TestRawModeHalfClose(t *testing.T) {
+	addr := startProxyListener(t, rawMode, startDrainServer(t))
+
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// binary data, an unterminated line and nothing rewritten
+	payload := []byte("pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n\x00\x01\xffpartial")
+	_, err = c.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, c.(*net.TCPConn).CloseWrite())
+
+	resp, err := io.ReadAll(c)
+	require.NoError(t, err)
+	require.Equal(t, append(payload, "bye"...), resp)
+}
+
+func TestRawModeUpstreamClose(t *testing.T) {
+	addr := startProxyListener(t, rawMode, startLineServers(t, "hello")...)
+
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	require.Equal(t, "hello", greeting(t, c))
+	_, err = c.Write([]byte("echo\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.(*net.TCPConn).CloseWrite())
+
+	// upstream closes on client EOF, the session ends without waiting for the deadline
+	rest, err := io.ReadAll(c)
+	require.NoError(t, err)
+	require.Equal(t, "echo\n", string(rest))
+}
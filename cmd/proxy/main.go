@@ -1,13 +1,14 @@
 package main
 
 import (
-	"bufio"
-	"context"
+	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,153 +22,157 @@ const (
 	// destinationPort = 8888
 )
 
+var (
+	rulesPath   = ""
+	metricsAddr = ""
+	adminAddr   = ""
+	tapDir      = ""
+
+	upstreamList = net.JoinHostPort(destinationHost, strconv.Itoa(destinationPort))
+	upstreamOpts = upstreamOptions{
+		Policy:      roundRobin,
+		DialTimeout: 5 * time.Second,
+		Retries:     1,
+	}
+	healthInterval = 10 * time.Second
+
+	listeners   listenerFlags
+	tunnelAuth  credentials
+	tunnelAllow destinations
+
+	rules    = mustRuleSet(defaultRules)
+	sessions = newSessionRegistry()
+)
+
 func main() {
+	flag.StringVar(&rulesPath, "rules", rulesPath, "JSON file with rewrite rules, Boguscoin addresses are rewritten when empty")
+	flag.StringVar(&metricsAddr, "metrics-addr", metricsAddr, "address serving rule hit metrics on /debug/vars, disabled when empty")
+	flag.StringVar(&adminAddr, "admin-addr", adminAddr, "address of the admin api listing and terminating sessions, disabled when empty")
+	flag.StringVar(&tapDir, "tap-dir", tapDir, "directory line sessions are recorded to as JSONL before and after rewrites, disabled when empty")
+	flag.StringVar(&upstreamList, "upstreams", upstreamList, "comma separated upstream addresses sessions are spread over")
+	flag.Var(&upstreamOpts.Policy, "balance", "upstream selection: round-robin or least-connections")
+	flag.DurationVar(&upstreamOpts.DialTimeout, "dial-timeout", upstreamOpts.DialTimeout, "timeout of upstream connects and health checks")
+	flag.IntVar(&upstreamOpts.Retries, "dial-retries", upstreamOpts.Retries, "times all upstreams are tried again when none accepted a session")
+	flag.DurationVar(&healthInterval, "health-interval", healthInterval, "period of upstream health checks, 0 disables them")
+	flag.Var(&listeners, "listen", "listener as [[socks5|connect+]line|raw@]addr[=upstream,...], repeatable, upstreams default to -upstreams")
+	flag.StringVar(&tunnelAuth.User, "proxy-user", tunnelAuth.User, "user socks5 and connect clients authenticate as, no authentication when empty")
+	flag.StringVar(&tunnelAuth.Password, "proxy-password", tunnelAuth.Password, "password of -proxy-user")
+	flag.Var(&tunnelAllow, "tunnel-allow", "comma separated host:port destinations socks5 and connect clients may reach, repeatable, none when empty")
+	flag.Parse()
+
+	if rulesPath != "" {
+		rs, err := LoadRules(rulesPath)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		rules = rs
+	}
+	if metricsAddr != "" {
+		go func() {
+			err := http.ListenAndServe(metricsAddr, nil)
+			if err != nil {
+				fmt.Printf("metrics listener failed: %v\n", err)
+			}
+		}()
+	}
+
+	if adminAddr != "" {
+		go func() {
+			err := http.ListenAndServe(adminAddr, newAdminHandler(sessions))
+			if err != nil {
+				fmt.Printf("admin listener failed: %v\n", err)
+			}
+		}()
+	}
+
 	startServer()
 }
 
-func startServer() {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", serverPort))
+func mustRuleSet(r []Rule) *RuleSet {
+	rs, err := NewRuleSet(r)
 	if err != nil {
-		fmt.Printf("failed to listen on port %d port: %v", serverPort, err)
-		os.Exit(1)
+		panic(err)
+	}
+	return rs
+}
+
+func startServer() {
+	configs := listeners
+	if len(configs) == 0 {
+		configs = listenerFlags{{mode: lineMode, addr: fmt.Sprintf(":%d", serverPort)}}
 	}
 
-	for {
-		conn, err := listener.Accept()
+	shared := newUpstreamPool(strings.Split(upstreamList, ","), upstreamOpts)
+	pools := []*upstreamPool{shared}
+
+	var wg sync.WaitGroup
+	for _, cfg := range configs {
+		pool := shared
+		if cfg.front == direct && len(cfg.upstreams) > 0 {
+			pool = newUpstreamPool(cfg.upstreams, upstreamOpts)
+			pools = append(pools, pool)
+		}
+
+		if cfg.front != direct && len(tunnelAllow) == 0 {
+			fmt.Printf("%s listener on %s allows no destinations, see -tunnel-allow\n", cfg.front, cfg.addr)
+		}
+
+		listener, err := net.Listen("tcp", cfg.addr)
 		if err != nil {
-			return
+			fmt.Printf("failed to listen on %s: %v", cfg.addr, err)
+			os.Exit(1)
 		}
 
-		conn.SetDeadline(time.Now().Add(time.Second * 120 * 10)) //TODO: remove 10
-		go handleConnection(conn)
+		wg.Add(1)
+		go func(pl *proxyListener) {
+			defer wg.Done()
+			pl.serve(listener)
+		}(&proxyListener{front: cfg.front, mode: cfg.mode, upstreams: pool})
+	}
+
+	if healthInterval > 0 {
+		for _, p := range pools {
+			go p.startHealthChecks(healthInterval, nil)
+		}
 	}
+	wg.Wait()
 }
 
-func handleConnection(sconn net.Conn) {
+func handleConnection(sconn net.Conn, upstreams *upstreamPool, mode proxyMode) {
 	defer func() {
 		fmt.Print("Closing source connection on proxy\n")
 		sconn.Close()
 	}()
 
-	request := make(chan []byte)
-	response := make(chan []byte)
-
-	dconn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", destinationHost, destinationPort))
+	dconn, up, err := upstreams.dial()
 	if err != nil {
-		fmt.Printf("failed to connect to `%s:%d`: %v", destinationHost, destinationPort, err)
+		fmt.Printf("failed to connect upstream: %v\n", err)
 		return
 	}
-	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
 		fmt.Println("Closing destination connection on proxy")
 		dconn.Close()
-		cancel()
+		upstreams.release(up)
 	}()
 
-	go ReadRequest(sconn, request, ctx, cancel)
-	go WriteRequest(dconn, request, ctx, cancel)
-	go ReadResponse(dconn, response, ctx, cancel)
-	WriteResponse(sconn, response, ctx, cancel)
-}
-
-func WriteResponse(sconn net.Conn, response <-chan []byte, ctx context.Context, cancel context.CancelFunc) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case resp := <-response:
-			_, err := sconn.Write(rewrite(resp))
-			if err != nil {
-				fmt.Println("failed on writing to source", err)
-				cancel()
-				return
-			}
-		}
-	}
-}
-
-func ReadRequest(conn net.Conn, request chan<- []byte, ctx context.Context, cancel context.CancelFunc) {
-	r := bufio.NewReader(conn)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			req, err := r.ReadBytes('\n')
-			if err != nil {
-				fmt.Println("failed on reading from source", err)
-				cancel()
-				return
-			}
-			request <- req
-		}
-	}
-}
-
-func ReadResponse(conn net.Conn, response chan<- []byte, ctx context.Context, cancel context.CancelFunc) {
-	r := bufio.NewReader(conn)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			resp, err := r.ReadBytes('\n')
-			if err != nil {
-				fmt.Println("failed on read from destination", err)
-				cancel()
-				return
-			}
-			response <- resp
-		}
-
-	}
+	runSession(sconn, dconn, up.addr, mode)
 }
 
-func WriteRequest(conn net.Conn, request <-chan []byte, ctx context.Context, cancel context.CancelFunc) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case req := <-request:
-			_, err := conn.Write(rewrite(req))
-			if err != nil {
-				fmt.Println("failed on write to destination", err)
-				cancel()
-				return
-			}
-		}
-	}
-}
-
-var (
-	bogusCoinExp = regexp.MustCompile("7[0-9a-zA-Z]{25,34}")
-	tonyCoin     = []byte("7YWHMfk9JZe0LM0g1ZauHuiSxhI")
-)
-
-func rewrite(msg []byte) []byte {
-	txt := string(msg)
-	indexes := bogusCoinExp.FindAllStringSubmatchIndex(txt, -1)
-	if indexes == nil {
-		return msg
-	}
-
-	newmsg := []string{}
-	newIndx := 0
-	for _, indexTuple := range indexes {
-		startIndex := indexTuple[0]
-		endIndex := indexTuple[1]
-		if (startIndex == 0 || msg[startIndex-1] == ' ') && (msg[endIndex] == '\n' || msg[endIndex] == ' ') {
-			newmsg = append(newmsg, txt[newIndx:startIndex])
-			newmsg = append(newmsg, string(tonyCoin))
-			newIndx = endIndex
+// runSession pipes the connections until the session ends, sessions are listed by the admin api and tapped meanwhile
+func runSession(sconn net.Conn, dconn net.Conn, upstream string, mode proxyMode) {
+	s := newSession(sconn, dconn, mode)
+	s.upstream = upstream
+	sessions.add(s)
+	defer sessions.remove(s)
+	if tapDir != "" && mode == lineMode {
+		t, err := openTap(tapDir, s)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+		} else {
+			s.tap = t
+			defer t.Close()
 		}
 	}
-
-	newmsg = append(newmsg, txt[newIndx:])
-
-	if len(newmsg) == 0 {
-		return msg
-	}
-
-	return []byte(strings.Join(newmsg, ""))
+	s.run()
 }
@@ -44,7 +44,7 @@ func TestBogusCoinReplacement(t *testing.T) {
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			rewritten := rewrite([]byte(tc.msg))
+			rewritten := rules.Apply([]byte(tc.msg), Upstream)
 			require.Equal(t, tc.exp, string(rewritten))
 		})
 	}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Direction of the traffic a rule rewrites, upstream is client to server
+type Direction string
+
+const (
+	Upstream   Direction = "upstream"
+	Downstream Direction = "downstream"
+	Both       Direction = "both"
+
+	// boundaries matching the beginning and the end of a line, the line terminator is not part of it
+	boundaryStart = "start"
+	boundaryEnd   = "end"
+)
+
+var (
+	// rewrites made by every rule, served on /debug/vars of the metrics listener
+	ruleHits = expvar.NewMap("proxy_rule_hits")
+	// lines passed through the rules by direction
+	rewrittenLines = expvar.NewMap("proxy_lines")
+)
+
+// Rule replaces matches of a regular expression, a match is replaced only when text preceding it ends with
+// one of Before boundaries and text following it starts with one of After boundaries, no boundaries allow any
+type Rule struct {
+	Name      string    `json:"name"`
+	Match     string    `json:"match"`
+	Before    []string  `json:"before,omitempty"`
+	After     []string  `json:"after,omitempty"`
+	Replace   string    `json:"replace"`
+	Direction Direction `json:"direction,omitempty"`
+
+	exp *regexp.Regexp
+}
+
+type RuleSet struct {
+	rules []*Rule
+}
+
+type rulesConfig struct {
+	Rules []Rule `json:"rules"`
+}
+
+// defaultRules replace Boguscoin addresses with Tony's one in both directions
+var defaultRules = []Rule{
+	{
+		Name:    "boguscoin",
+		Match:   "7[0-9a-zA-Z]{25,34}",
+		Before:  []string{boundaryStart, " "},
+		After:   []string{" ", boundaryEnd},
+		Replace: "7YWHMfk9JZe0LM0g1ZauHuiSxhI",
+	},
+}
+
+func NewRuleSet(rules []Rule) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for i := range rules {
+		r := rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i)
+		}
+
+		switch r.Direction {
+		case "":
+			r.Direction = Both
+		case Upstream, Downstream, Both:
+		default:
+			return nil, fmt.Errorf("rule %s: unknown direction %q", r.Name, r.Direction)
+		}
+
+		exp, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.exp = exp
+		rs.rules = append(rs.rules, &r)
+	}
+	return rs, nil
+}
+
+// LoadRules reads a JSON file with a `rules` list, rules are applied in the order of the list
+func LoadRules(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	var cfg rulesConfig
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %w", path, err)
+	}
+	return NewRuleSet(cfg.Rules)
+}
+
+// Apply runs rules of the direction over the line, every rule rewrites the output of the previous one
+func (rs *RuleSet) Apply(line []byte, dir Direction) []byte {
+	line, _ = rs.applyCounting(line, dir)
+	return line
+}
+
+// applyCounting is Apply also returning the number of rewrites made
+func (rs *RuleSet) applyCounting(line []byte, dir Direction) ([]byte, int) {
+	rewrittenLines.Add(string(dir), 1)
+	total := 0
+	for _, r := range rs.rules {
+		if r.Direction != Both && r.Direction != dir {
+			continue
+		}
+
+		var hits int
+		line, hits = r.apply(line)
+		if hits > 0 {
+			ruleHits.Add(r.Name, int64(hits))
+			total += hits
+		}
+	}
+	return line, total
+}
+
+// Rules lists names of the rules in the order they are applied
+func (rs *RuleSet) Rules() []string {
+	names := make([]string, 0, len(rs.rules))
+	for _, r := range rs.rules {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func (r *Rule) apply(line []byte) ([]byte, int) {
+	txt := string(line)
+	matches := r.exp.FindAllStringSubmatchIndex(txt, -1)
+	if matches == nil {
+		return line, 0
+	}
+
+	var out []byte
+	last, hits := 0, 0
+	for _, m := range matches {
+		if !r.bounded(txt, m[0], m[1]) {
+			continue
+		}
+		out = append(out, txt[last:m[0]]...)
+		out = r.exp.ExpandString(out, r.Replace, txt, m)
+		last = m[1]
+		hits++
+	}
+
+	if hits == 0 {
+		return line, 0
+	}
+	return append(out, txt[last:]...), hits
+}
+
+func (r *Rule) bounded(txt string, start int, end int) bool {
+	content := strings.TrimRight(txt, "\r\n")
+	return matchBoundary(r.Before, func(b string) bool {
+		if b == boundaryStart {
+			return start == 0
+		}
+		return strings.HasSuffix(txt[:start], b)
+	}) && matchBoundary(r.After, func(b string) bool {
+		if b == boundaryEnd {
+			return end >= len(content)
+		}
+		return strings.HasPrefix(txt[end:], b)
+	})
+}
+
+func matchBoundary(boundaries []string, match func(string) bool) bool {
+	if len(boundaries) == 0 {
+		return true
+	}
+	for _, b := range boundaries {
+		if match(b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"expvar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleSet(t *testing.T) {
+	rs, err := NewRuleSet([]Rule{
+		{Name: "swap", Match: `(\w+)@(\w+)`, Replace: "$2@$1", Direction: Upstream},
+		{Name: "mask", Match: `secret`, Before: []string{" "}, After: []string{" ", "!", boundaryEnd}, Replace: "******"},
+		{Name: "after-swap", Match: `b@a`, Replace: "ordered", Direction: Upstream},
+		{Name: "down", Match: `^hello`, Replace: "HELLO", Direction: Downstream},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"swap", "mask", "after-swap", "down"}, rs.Rules())
+
+	for _, tc := range []struct {
+		desc string
+		dir  Direction
+		line string
+		exp  string
+	}{
+		{desc: "rules apply in order", dir: Upstream, line: "a@b\n", exp: "ordered\n"},
+		{desc: "direction", dir: Downstream, line: "hello a@b\n", exp: "HELLO a@b\n"},
+		{desc: "boundaries", dir: Downstream, line: "my secret secrets secret! secret\n", exp: "my ****** secrets ******! ******\n"},
+		{desc: "no boundary before line start", dir: Downstream, line: "secret\n", exp: "secret\n"},
+		{desc: "line without terminator", dir: Downstream, line: "the secret", exp: "the ******"},
+		{desc: "no match", dir: Upstream, line: "nothing here\n", exp: "nothing here\n"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.exp, string(rs.Apply([]byte(tc.line), tc.dir)))
+		})
+	}
+}
+
+func TestRuleHitsMetrics(t *testing.T) {
+	rs, err := NewRuleSet([]Rule{{Name: "metrics-test", Match: "x", Replace: "y"}})
+	require.NoError(t, err)
+
+	hits := func() int64 {
+		if v, ok := ruleHits.Get("metrics-test").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := hits()
+
+	rs.Apply([]byte("xxx\n"), Upstream)
+	rs.Apply([]byte("x\n"), Downstream)
+	require.Equal(t, before+4, hits())
+}
+
+func TestLoadRules(t *testing.T) {
+	rs, err := LoadRules("rules.example.json")
+	require.NoError(t, err)
+	require.Equal(t, []string{"boguscoin", "shout-server-greeting", "anonymize-name"}, rs.Rules())
+	require.Equal(t, "[anonymous] pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n",
+		string(rs.Apply([]byte("[bob] pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n"), Downstream)))
+	require.Equal(t, "[bob] hi\n", string(rs.Apply([]byte("[bob] hi\n"), Upstream)))
+
+	dir := t.TempDir()
+	for name, cfg := range map[string]string{
+		"direction": `{"rules": [{"match": "a", "direction": "sideways"}]}`,
+		"regexp":    `{"rules": [{"match": "(a"}]}`,
+		"json":      `{"rules": [`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		require.NoError(t, os.WriteFile(path, []byte(cfg), 0644))
+		_, err := LoadRules(path)
+		require.Error(t, err, name)
+	}
+}
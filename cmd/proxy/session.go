@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session pipes traffic between a client (source) and an upstream (destination), it ends when both directions
+// are done or any of them fails, then both connections are closed and all its goroutines exit
+type session struct {
+	// counters are updated atomically and read by the admin api while the session runs
+	upstreamBytes   int64
+	downstreamBytes int64
+	rewrites        int64
+
+	id       uint64
+	source   string
+	upstream string
+	started  time.Time
+	// nil when lines are not recorded
+	tap *tap
+
+	sconn net.Conn
+	dconn net.Conn
+	mode  proxyMode
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// directions still flowing
+	open int32
+}
+
+func newSession(sconn net.Conn, dconn net.Conn, mode proxyMode) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &session{
+		source:   sconn.RemoteAddr().String(),
+		upstream: dconn.RemoteAddr().String(),
+		started:  time.Now(),
+		sconn:    sconn,
+		dconn:    dconn,
+		mode:     mode,
+		ctx:      ctx,
+		cancel:   cancel,
+		open:     2,
+	}
+}
+
+// run blocks until the session is over
+func (s *session) run() {
+	if s.mode == rawMode {
+		s.start(
+			func() { s.copyRaw(s.dconn, s.sconn, Upstream) },
+			func() { s.copyRaw(s.sconn, s.dconn, Downstream) },
+		)
+	} else {
+		request := make(chan []byte)
+		response := make(chan []byte)
+		s.start(
+			func() { s.readLines(s.sconn, request, "source") },
+			func() { s.writeLines(s.dconn, request, Upstream) },
+			func() { s.readLines(s.dconn, response, "destination") },
+			func() { s.writeLines(s.sconn, response, Downstream) },
+		)
+	}
+
+	<-s.ctx.Done()
+	// reads blocked on the connections return only once they are closed
+	s.sconn.Close()
+	s.dconn.Close()
+	s.wg.Wait()
+}
+
+func (s *session) start(fns ...func()) {
+	for _, fn := range fns {
+		s.wg.Add(1)
+		go func(fn func()) {
+			defer s.wg.Done()
+			fn()
+		}(fn)
+	}
+}
+
+// stop ends the session, run returns once its goroutines exited
+func (s *session) stop() {
+	s.cancel()
+}
+
+func (s *session) sent(dir Direction, n int) {
+	if dir == Upstream {
+		atomic.AddInt64(&s.upstreamBytes, int64(n))
+	} else {
+		atomic.AddInt64(&s.downstreamBytes, int64(n))
+	}
+}
+
+func (s *session) directionDone() {
+	if atomic.AddInt32(&s.open, -1) == 0 {
+		s.cancel()
+	}
+}
+
+func (s *session) fail(msg string, err error) {
+	if s.ctx.Err() == nil {
+		fmt.Println(msg, err)
+	}
+	s.cancel()
+}
+
+// readLines forwards lines until EOF, a last line without a terminator is forwarded too
+// and EOF is passed on by closing the channel
+func (s *session) readLines(conn net.Conn, lines chan<- []byte, from string) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			select {
+			case lines <- line:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+
+		if err == io.EOF {
+			close(lines)
+			return
+		}
+		if err != nil {
+			s.fail("failed on reading from "+from, err)
+			return
+		}
+	}
+}
+
+func (s *session) writeLines(conn net.Conn, lines <-chan []byte, dir Direction) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				closeWrite(conn)
+				s.directionDone()
+				return
+			}
+
+			s.tap.record(dir, stageBefore, line)
+			line, hits := rules.applyCounting(line, dir)
+			s.tap.record(dir, stageAfter, line)
+			atomic.AddInt64(&s.rewrites, int64(hits))
+			n, err := conn.Write(line)
+			s.sent(dir, n)
+			if err != nil {
+				s.fail(fmt.Sprintf("failed on writing %s", dir), err)
+				return
+			}
+		}
+	}
+}
+
+func (s *session) copyRaw(dst net.Conn, src net.Conn, dir Direction) {
+	_, err := io.Copy(&countingWriter{w: dst, count: func(n int) { s.sent(dir, n) }}, src)
+	if err != nil {
+		s.fail(fmt.Sprintf("failed on copying %s", dir), err)
+		return
+	}
+	closeWrite(dst)
+	s.directionDone()
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count func(n int)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count(n)
+	return n, err
+}
+
+// closeWrite passes EOF on while the other direction keeps flowing
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startHangupServer greets every connection and closes it right away
+func startHangupServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(c, "bye\n")
+			c.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func waitForGoroutines(t *testing.T, max int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > max {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("%d goroutines left, expected at most %d\n%s", runtime.NumGoroutine(), max, buf[:n])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionsDoNotLeak(t *testing.T) {
+	echo := startLineServers(t, "echo")
+	proxies := map[proxyMode]string{
+		lineMode: startProxyListener(t, lineMode, echo...),
+		rawMode:  startProxyListener(t, rawMode, echo...),
+	}
+	hangup := startProxyListener(t, lineMode, startHangupServer(t))
+	// a flood of lines the client never reads back keeps sessions blocked on writes
+	flood := strings.Repeat("flood\n", 64*1024)
+
+	baseline := runtime.NumGoroutine()
+
+	kills := []struct {
+		name     string
+		sessions int
+		kill     func(c *net.TCPConn)
+	}{
+		{"client closes", 200, func(c *net.TCPConn) {
+			c.Close()
+		}},
+		{"client resets", 200, func(c *net.TCPConn) {
+			c.SetLinger(0)
+			c.Close()
+		}},
+		{"client half-closes", 200, func(c *net.TCPConn) {
+			c.CloseWrite()
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1024)
+			for {
+				if _, err := c.Read(buf); err != nil {
+					break
+				}
+			}
+			c.Close()
+		}},
+		{"client stops reading", 20, func(c *net.TCPConn) {
+			c.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+			c.Write([]byte(flood))
+			c.Close()
+		}},
+	}
+
+	for _, mode := range []proxyMode{lineMode, rawMode} {
+		for _, k := range kills {
+			t.Run(fmt.Sprintf("%s %s", mode, k.name), func(t *testing.T) {
+				for i := 0; i < k.sessions; i++ {
+					c, err := net.Dial("tcp", proxies[mode])
+					require.NoError(t, err)
+					require.Equal(t, "echo", greeting(t, c))
+					k.kill(c.(*net.TCPConn))
+				}
+			})
+		}
+	}
+
+	t.Run("upstream hangs up", func(t *testing.T) {
+		for i := 0; i < 200; i++ {
+			c, err := net.Dial("tcp", hangup)
+			require.NoError(t, err)
+			require.Equal(t, "bye", greeting(t, c))
+			c.Close()
+		}
+	})
+
+	waitForGoroutines(t, baseline)
+}
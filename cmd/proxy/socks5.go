@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// SOCKS5 (RFC 1928) with username/password authentication (RFC 1929), only CONNECT is supported
+const (
+	socksVersion     = 5
+	socksAuthVersion = 1
+
+	socksNoAuth       = 0x00
+	socksUserPassword = 0x02
+	socksNoAcceptable = 0xff
+
+	socksConnect = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded           = 0
+	socksFailure             = 1
+	socksNotAllowed          = 2
+	socksHostUnreachable     = 4
+	socksRefused             = 5
+	socksCommandNotSupported = 7
+	socksAddressNotSupported = 8
+)
+
+// socksHandshake negotiates authentication and reads the CONNECT request, it returns the requested address
+func socksHandshake(rw io.ReadWriter, auth credentials) (string, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(rw, header)
+	if err != nil {
+		return "", fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(rw, methods)
+	if err != nil {
+		return "", fmt.Errorf("failed to read greeting: %w", err)
+	}
+
+	method := byte(socksNoAuth)
+	if auth.required() {
+		method = socksUserPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		rw.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", fmt.Errorf("no acceptable authentication method in %v", methods)
+	}
+	_, err = rw.Write([]byte{socksVersion, method})
+	if err != nil {
+		return "", err
+	}
+
+	if method == socksUserPassword {
+		err = socksAuthenticate(rw, auth)
+		if err != nil {
+			return "", err
+		}
+	}
+	return socksReadRequest(rw)
+}
+
+func socksAuthenticate(rw io.ReadWriter, auth credentials) error {
+	version := make([]byte, 1)
+	_, err := io.ReadFull(rw, version)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if version[0] != socksAuthVersion {
+		return fmt.Errorf("unsupported authentication version %d", version[0])
+	}
+	user, err := readString8(rw)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials: %w", err)
+	}
+	password, err := readString8(rw)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	if !auth.valid(user, password) {
+		rw.Write([]byte{socksAuthVersion, 1})
+		return errTunnelAuth
+	}
+	_, err = rw.Write([]byte{socksAuthVersion, 0})
+	return err
+}
+
+func socksReadRequest(rw io.ReadWriter) (string, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(rw, header)
+	if err != nil {
+		return "", fmt.Errorf("failed to read request: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported socks version %d", header[0])
+	}
+
+	var host string
+	switch header[3] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if header[3] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		_, err = io.ReadFull(rw, ip)
+		host = ip.String()
+	case socksDomain:
+		host, err = readString8(rw)
+	default:
+		writeSocksReply(rw, socksAddressNotSupported, nil)
+		return "", fmt.Errorf("unsupported address type %d", header[3])
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read request: %w", err)
+	}
+
+	port := make([]byte, 2)
+	_, err = io.ReadFull(rw, port)
+	if err != nil {
+		return "", fmt.Errorf("failed to read request: %w", err)
+	}
+
+	if header[1] != socksConnect {
+		writeSocksReply(rw, socksCommandNotSupported, nil)
+		return "", fmt.Errorf("unsupported command %d", header[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply tells the client whether connecting the upstream succeeded
+func socksReply(w io.Writer, dconn net.Conn, dialErr error) error {
+	switch {
+	case dialErr == nil:
+		return writeSocksReply(w, socksSucceeded, dconn.LocalAddr())
+	case dialErr == errTunnelNotAllowed:
+		return writeSocksReply(w, socksNotAllowed, nil)
+	case errors.Is(dialErr, syscall.ECONNREFUSED):
+		return writeSocksReply(w, socksRefused, nil)
+	default:
+		return writeSocksReply(w, socksHostUnreachable, nil)
+	}
+}
+
+// writeSocksReply sends the reply with the address the proxy connects the upstream from, zeros when there is none
+func writeSocksReply(w io.Writer, code byte, bound net.Addr) error {
+	ip, port := net.IPv4zero, 0
+	if addr, ok := bound.(*net.TCPAddr); ok {
+		ip, port = addr.IP, addr.Port
+	}
+
+	reply := []byte{socksVersion, code, 0}
+	if ip4 := ip.To4(); ip4 != nil {
+		reply = append(reply, socksIPv4)
+		reply = append(reply, ip4...)
+	} else {
+		reply = append(reply, socksIPv6)
+		reply = append(reply, ip.To16()...)
+	}
+	reply = append(reply, byte(port>>8), byte(port))
+
+	_, err := w.Write(reply)
+	return err
+}
+
+func readString8(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+	_, err := io.ReadFull(r, length)
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, length[0])
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	stageBefore = "before"
+	stageAfter  = "after"
+)
+
+// tapRecord is a line of a tap file, a line passing the rules is recorded before and after the rewrite.
+// Line is kept as bytes, encoded as base64, since sessions are not required to be valid UTF-8
+type tapRecord struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	Stage     string    `json:"stage"`
+	Line      []byte    `json:"line"`
+}
+
+// tap writes the transcript of a session to a JSONL file
+type tap struct {
+	lock sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// openTap creates the tap file of the session in dir, the id and the start time keep names of sessions apart
+func openTap(dir string, s *session) (*tap, error) {
+	name := fmt.Sprintf("session-%d-%s.jsonl", s.id, s.started.Format("20060102T150405"))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tap: %w", err)
+	}
+	return &tap{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record is a no-op on a nil tap, a failed write does not stop the session
+func (t *tap) record(dir Direction, stage string, line []byte) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err := t.enc.Encode(tapRecord{Time: time.Now(), Direction: dir, Stage: stage, Line: line})
+	if err != nil {
+		fmt.Printf("failed to write tap %s: %v\n", t.f.Name(), err)
+	}
+}
+
+func (t *tap) Close() error {
+	return t.f.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTap(t *testing.T) {
+	dir := t.TempDir()
+	tapDir = dir
+	t.Cleanup(func() { tapDir = "" })
+
+	addr := startProxyListener(t, lineMode, startLineServers(t, "echo")...)
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, "echo", greeting(t, c))
+
+	r := bufio.NewReader(c)
+	fmt.Fprint(c, "pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n")
+	_, err = r.ReadString('\n')
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "session-*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []tapRecord
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var rec tapRecord
+		require.NoError(t, dec.Decode(&rec))
+		require.WithinDuration(t, time.Now(), rec.Time, 5*time.Second)
+		rec.Time = time.Time{}
+		records = append(records, rec)
+	}
+	require.Equal(t, []tapRecord{
+		{Direction: Downstream, Stage: stageBefore, Line: []byte("echo\n")},
+		{Direction: Downstream, Stage: stageAfter, Line: []byte("echo\n")},
+		{Direction: Upstream, Stage: stageBefore, Line: []byte("pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n")},
+		{Direction: Upstream, Stage: stageAfter, Line: []byte("pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n")},
+		{Direction: Downstream, Stage: stageBefore, Line: []byte("pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n")},
+		{Direction: Downstream, Stage: stageAfter, Line: []byte("pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n")},
+	}, records)
+}
+
+func TestTapKeepsInvalidUTF8(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "tap.jsonl"))
+	require.NoError(t, err)
+	tp := &tap{f: f, enc: json.NewEncoder(f)}
+	defer tp.Close()
+
+	line := []byte("caf\xe9 \xff\n")
+	tp.record(Upstream, stageBefore, line)
+
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	var rec tapRecord
+	require.NoError(t, json.Unmarshal(data, &rec))
+	require.Equal(t, line, rec.Line)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+var (
+	errTunnelAuth       = errors.New("authentication failed")
+	errTunnelNotAllowed = errors.New("destination not allowed")
+)
+
+// credentials socks5 and connect clients have to present, none are required when User is empty
+type credentials struct {
+	User     string
+	Password string
+}
+
+func (c credentials) required() bool {
+	return c.User != ""
+}
+
+func (c credentials) valid(user string, password string) bool {
+	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(c.User)) == 1
+	passwordOk := subtle.ConstantTimeCompare([]byte(password), []byte(c.Password)) == 1
+	return userOk && passwordOk
+}
+
+// destinations are the host:port targets socks5 and connect clients may tunnel to, nothing is allowed when empty
+// so a tunnel listener is not an open relay
+type destinations map[string]bool
+
+// normalizeDestination lowercases the host, targets are compared the way the client names them
+func normalizeDestination(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		return "", fmt.Errorf("invalid destination %q: %w", addr, err)
+	}
+	return net.JoinHostPort(strings.ToLower(host), port), nil
+}
+
+func (d destinations) allows(target string) bool {
+	addr, err := normalizeDestination(target)
+	return err == nil && d[addr]
+}
+
+func (d *destinations) String() string {
+	var addrs []string
+	for addr := range *d {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+// Set adds comma separated destinations, the flag can be repeated
+func (d *destinations) Set(v string) error {
+	if *d == nil {
+		*d = destinations{}
+	}
+	for _, addr := range strings.Split(v, ",") {
+		addr, err := normalizeDestination(addr)
+		if err != nil {
+			return err
+		}
+		(*d)[addr] = true
+	}
+	return nil
+}
+
+// handleTunnel reads the upstream from the socks5 or connect handshake of the client and pipes the session to it
+func handleTunnel(sconn net.Conn, front frontEnd, mode proxyMode) {
+	defer func() {
+		fmt.Print("Closing source connection on proxy\n")
+		sconn.Close()
+	}()
+
+	client := sconn
+	var target string
+	var err error
+	if front == socks5 {
+		target, err = socksHandshake(sconn, tunnelAuth)
+	} else {
+		r := bufio.NewReader(sconn)
+		target, err = connectHandshake(r, sconn, tunnelAuth)
+		// bytes sent by the client right after the request are buffered already
+		client = &bufferedConn{Conn: sconn, r: r}
+	}
+	if err != nil {
+		fmt.Printf("%s handshake with %s failed: %v\n", front, sconn.RemoteAddr(), err)
+		return
+	}
+
+	var dconn net.Conn
+	if tunnelAllow.allows(target) {
+		dconn, err = net.DialTimeout("tcp", target, upstreamOpts.DialTimeout)
+	} else {
+		err = errTunnelNotAllowed
+	}
+	var replyErr error
+	if front == socks5 {
+		replyErr = socksReply(sconn, dconn, err)
+	} else {
+		replyErr = connectReply(sconn, err)
+	}
+	if err != nil {
+		fmt.Printf("failed to connect to `%s`: %v\n", target, err)
+		return
+	}
+	defer func() {
+		fmt.Println("Closing destination connection on proxy")
+		dconn.Close()
+	}()
+	if replyErr != nil {
+		return
+	}
+
+	runSession(client, dconn, target, mode)
+}
+
+// bufferedConn reads what was buffered while reading the handshake before reading the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	closeWrite(c.Conn)
+	return nil
+}
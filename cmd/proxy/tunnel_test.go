@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	boguscoinLine  = "pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n"
+	rewrittenLine  = "pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n"
+	testUser       = "bob"
+	testPassword   = "secret"
+	closedUpstream = "127.0.0.1:1"
+	deniedUpstream = "127.0.0.1:2"
+)
+
+// allowTunnels lets tunnel clients reach the targets until the test ends
+func allowTunnels(t *testing.T, targets ...string) {
+	tunnelAllow = destinations{}
+	for _, target := range targets {
+		require.NoError(t, tunnelAllow.Set(target))
+	}
+	t.Cleanup(func() { tunnelAllow = nil })
+}
+
+func startTunnelListener(t *testing.T, front frontEnd, mode proxyMode, auth credentials) string {
+	tunnelAuth = auth
+	t.Cleanup(func() { tunnelAuth = credentials{} })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go (&proxyListener{front: front, mode: mode}).serve(l)
+	return l.Addr().String()
+}
+
+// requireEcho checks the upstream greets and echoes back what the rules rewrote
+func requireEcho(t *testing.T, c net.Conn, r *bufio.Reader, exp string) {
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "echo\n", line)
+
+	fmt.Fprint(c, boguscoinLine)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, exp, line)
+}
+
+func TestSocks5(t *testing.T) {
+	upstream := startLineServers(t, "echo")[0]
+	_, port, _ := net.SplitHostPort(upstream)
+	allowTunnels(t, upstream, net.JoinHostPort("localhost", port))
+
+	for _, tc := range []struct {
+		desc   string
+		mode   proxyMode
+		auth   credentials
+		client *proxy.Auth
+		target string
+		exp    string
+	}{
+		{desc: "no auth", mode: lineMode, target: upstream, exp: rewrittenLine},
+		{desc: "domain target", mode: lineMode, target: net.JoinHostPort("localhost", port), exp: rewrittenLine},
+		{desc: "raw mode", mode: rawMode, target: upstream, exp: boguscoinLine},
+		{
+			desc:   "username and password",
+			mode:   lineMode,
+			auth:   credentials{User: testUser, Password: testPassword},
+			client: &proxy.Auth{User: testUser, Password: testPassword},
+			target: upstream,
+			exp:    rewrittenLine,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			addr := startTunnelListener(t, socks5, tc.mode, tc.auth)
+			dialer, err := proxy.SOCKS5("tcp", addr, tc.client, proxy.Direct)
+			require.NoError(t, err)
+
+			c, err := dialer.Dial("tcp", tc.target)
+			require.NoError(t, err)
+			defer c.Close()
+			requireEcho(t, c, bufio.NewReader(c), tc.exp)
+		})
+	}
+}
+
+func TestSocks5Failures(t *testing.T) {
+	upstream := startLineServers(t, "echo")[0]
+	auth := credentials{User: testUser, Password: testPassword}
+	allowTunnels(t, upstream, closedUpstream)
+
+	t.Run("wrong password", func(t *testing.T) {
+		dialer, err := proxy.SOCKS5("tcp", startTunnelListener(t, socks5, lineMode, auth), &proxy.Auth{User: testUser, Password: "guess"}, proxy.Direct)
+		require.NoError(t, err)
+		_, err = dialer.Dial("tcp", upstream)
+		require.Error(t, err)
+	})
+
+	t.Run("authentication required", func(t *testing.T) {
+		dialer, err := proxy.SOCKS5("tcp", startTunnelListener(t, socks5, lineMode, auth), nil, proxy.Direct)
+		require.NoError(t, err)
+		_, err = dialer.Dial("tcp", upstream)
+		require.Error(t, err)
+	})
+
+	for _, tc := range []struct {
+		desc    string
+		request []byte
+		exp     byte
+	}{
+		{desc: "refused", request: []byte{socksVersion, socksConnect, 0, socksIPv4, 127, 0, 0, 1, 0, 1}, exp: socksRefused},
+		{desc: "not allowed", request: []byte{socksVersion, socksConnect, 0, socksIPv4, 127, 0, 0, 1, 0, 2}, exp: socksNotAllowed},
+		{desc: "bind", request: []byte{socksVersion, 2, 0, socksIPv4, 127, 0, 0, 1, 0, 1}, exp: socksCommandNotSupported},
+		{desc: "unknown address type", request: []byte{socksVersion, socksConnect, 0, 9}, exp: socksAddressNotSupported},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c, err := net.Dial("tcp", startTunnelListener(t, socks5, lineMode, credentials{}))
+			require.NoError(t, err)
+			defer c.Close()
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+			_, err = c.Write([]byte{socksVersion, 1, socksNoAuth})
+			require.NoError(t, err)
+			method := make([]byte, 2)
+			_, err = c.Read(method)
+			require.NoError(t, err)
+			require.Equal(t, []byte{socksVersion, socksNoAuth}, method)
+
+			_, err = c.Write(tc.request)
+			require.NoError(t, err)
+			reply := make([]byte, 10)
+			_, err = c.Read(reply)
+			require.NoError(t, err)
+			require.Equal(t, []byte{socksVersion, tc.exp}, reply[:2])
+		})
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	upstream := startLineServers(t, "echo")[0]
+	auth := credentials{User: testUser, Password: testPassword}
+	authHeader := "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(testUser+":"+testPassword)) + "\r\n"
+	allowTunnels(t, upstream, closedUpstream)
+
+	for _, tc := range []struct {
+		desc    string
+		mode    proxyMode
+		auth    credentials
+		request string
+		status  int
+		exp     string
+	}{
+		{desc: "line mode", mode: lineMode, request: "CONNECT " + upstream + " HTTP/1.1\r\nHost: " + upstream + "\r\n\r\n", status: http.StatusOK, exp: rewrittenLine},
+		{desc: "raw mode", mode: rawMode, request: "CONNECT " + upstream + " HTTP/1.1\r\n\r\n", status: http.StatusOK, exp: boguscoinLine},
+		{desc: "authenticated", mode: lineMode, auth: auth, request: "CONNECT " + upstream + " HTTP/1.1\r\n" + authHeader + "\r\n", status: http.StatusOK, exp: rewrittenLine},
+		{desc: "authentication required", mode: lineMode, auth: auth, request: "CONNECT " + upstream + " HTTP/1.1\r\n\r\n", status: http.StatusProxyAuthRequired},
+		{desc: "refused", mode: lineMode, request: "CONNECT " + closedUpstream + " HTTP/1.1\r\n\r\n", status: http.StatusBadGateway},
+		{desc: "not allowed", mode: lineMode, request: "CONNECT " + deniedUpstream + " HTTP/1.1\r\n\r\n", status: http.StatusForbidden},
+		{desc: "not connect", mode: lineMode, request: "GET http://" + upstream + "/ HTTP/1.1\r\n\r\n", status: http.StatusMethodNotAllowed},
+		{desc: "no port", mode: lineMode, request: "CONNECT localhost HTTP/1.1\r\n\r\n", status: http.StatusBadRequest},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c, err := net.Dial("tcp", startTunnelListener(t, httpConnect, tc.mode, tc.auth))
+			require.NoError(t, err)
+			defer c.Close()
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+			_, err = fmt.Fprint(c, tc.request)
+			require.NoError(t, err)
+			r := bufio.NewReader(c)
+			resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+			require.NoError(t, err)
+			require.Equal(t, tc.status, resp.StatusCode)
+			if tc.status == http.StatusOK {
+				requireEcho(t, c, r, tc.exp)
+			}
+		})
+	}
+}
+
+func TestHTTPConnectPipelined(t *testing.T) {
+	upstream := startLineServers(t, "echo")[0]
+	allowTunnels(t, upstream)
+	c, err := net.Dial("tcp", startTunnelListener(t, httpConnect, lineMode, credentials{}))
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// the line comes in the same write as the request and ends up buffered with it
+	_, err = fmt.Fprint(c, "CONNECT "+upstream+" HTTP/1.1\r\n\r\n"+boguscoinLine)
+	require.NoError(t, err)
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var lines []string
+	for len(lines) < 2 {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, line)
+	}
+	require.Equal(t, []string{"echo\n", rewrittenLine}, lines)
+}
+
+func TestDestinations(t *testing.T) {
+	var d destinations
+	require.False(t, d.allows("localhost:80"))
+
+	require.NoError(t, d.Set("LocalHost:80, 127.0.0.1:8080"))
+	require.NoError(t, d.Set("[::1]:80"))
+	require.Equal(t, "127.0.0.1:8080,[::1]:80,localhost:80", d.String())
+	require.True(t, d.allows("localhost:80"))
+	require.True(t, d.allows("LOCALHOST:80"))
+	require.False(t, d.allows("localhost:81"))
+	require.False(t, d.allows("127.0.0.1:80"))
+
+	require.Error(t, d.Set("localhost"))
+}
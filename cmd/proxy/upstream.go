@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	retryDelay = 200 * time.Millisecond
+)
+
+var (
+	errNoUpstream = errors.New("no upstream available")
+)
+
+// balancePolicy picks the upstream a new session connects to
+type balancePolicy int
+
+const (
+	roundRobin balancePolicy = iota
+	leastConnections
+)
+
+func (p balancePolicy) String() string {
+	switch p {
+	case roundRobin:
+		return "round-robin"
+	case leastConnections:
+		return "least-connections"
+	default:
+		return fmt.Sprintf("balancePolicy(%d)", int(p))
+	}
+}
+
+func (p *balancePolicy) Set(v string) error {
+	switch v {
+	case "round-robin":
+		*p = roundRobin
+	case "least-connections":
+		*p = leastConnections
+	default:
+		return fmt.Errorf("unknown balance policy %q (round-robin, least-connections)", v)
+	}
+	return nil
+}
+
+type upstreamOptions struct {
+	Policy      balancePolicy
+	DialTimeout time.Duration
+	// rounds over all upstreams repeated after every one of them failed
+	Retries int
+}
+
+type upstream struct {
+	addr    string
+	healthy bool
+	active  int
+}
+
+// upstreamPool spreads sessions over healthy upstreams and fails over to the next one when dialing fails
+type upstreamPool struct {
+	opts upstreamOptions
+
+	lock      sync.Mutex
+	upstreams []*upstream
+	next      int
+}
+
+func newUpstreamPool(addrs []string, opts upstreamOptions) *upstreamPool {
+	p := &upstreamPool{opts: opts}
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		// upstreams are assumed healthy until a dial or a health check fails
+		p.upstreams = append(p.upstreams, &upstream{addr: addr, healthy: true})
+	}
+	return p
+}
+
+// candidates orders upstreams to try, healthy ones by policy first then the unhealthy ones as the last resort
+func (p *upstreamPool) candidates() []*upstream {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	n := len(p.upstreams)
+	ordered := make([]*upstream, 0, n)
+	for i := 0; i < n; i++ {
+		ordered = append(ordered, p.upstreams[(p.next+i)%n])
+	}
+	if n > 0 {
+		p.next = (p.next + 1) % n
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].healthy != ordered[j].healthy {
+			return ordered[i].healthy
+		}
+		if p.opts.Policy == leastConnections {
+			return ordered[i].active < ordered[j].active
+		}
+		return false
+	})
+	return ordered
+}
+
+// dial connects to the first upstream accepting the connection, release has to be called once it is closed
+func (p *upstreamPool) dial() (net.Conn, *upstream, error) {
+	for attempt := 0; attempt <= p.opts.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryDelay)
+		}
+
+		for _, u := range p.candidates() {
+			conn, err := net.DialTimeout("tcp", u.addr, p.opts.DialTimeout)
+			if err != nil {
+				fmt.Printf("failed to connect to `%s`: %v\n", u.addr, err)
+				p.setHealthy(u, false)
+				continue
+			}
+
+			p.lock.Lock()
+			u.active++
+			p.lock.Unlock()
+			p.setHealthy(u, true)
+			return conn, u, nil
+		}
+	}
+	return nil, nil, errNoUpstream
+}
+
+func (p *upstreamPool) release(u *upstream) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	u.active--
+}
+
+func (p *upstreamPool) setHealthy(u *upstream, healthy bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if u.healthy != healthy {
+		fmt.Printf("upstream %s healthy: %v\n", u.addr, healthy)
+	}
+	u.healthy = healthy
+}
+
+// checkHealth connects to every upstream and closes the connection right away
+func (p *upstreamPool) checkHealth() {
+	p.lock.Lock()
+	upstreams := append([]*upstream{}, p.upstreams...)
+	p.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, u := range upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", u.addr, p.opts.DialTimeout)
+			if err == nil {
+				conn.Close()
+			}
+			p.setHealthy(u, err == nil)
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (p *upstreamPool) startHealthChecks(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.checkHealth()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testUpstreamOpts = upstreamOptions{Policy: roundRobin, DialTimeout: time.Second}
+
+// startLineServer greets every connection with its name and echoes lines back
+func startLineServer(t *testing.T, name string, addr string) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				fmt.Fprintf(c, "%s\n", name)
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fmt.Fprint(c, line)
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func greeting(t *testing.T, c net.Conn) string {
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	require.NoError(t, err)
+	return line[:len(line)-1]
+}
+
+func startLineServers(t *testing.T, names ...string) []string {
+	var addrs []string
+	for _, n := range names {
+		addrs = append(addrs, startLineServer(t, n, "127.0.0.1:0").Addr().String())
+	}
+	return addrs
+}
+
+// orderedLast reports whether addr is tried after the other upstreams on consecutive dials, which rotation alone
+// does not do so the upstream is unhealthy
+func orderedLast(p *upstreamPool, addr string) bool {
+	for i := 0; i < 2; i++ {
+		c := p.candidates()
+		if c[len(c)-1].addr != addr {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRoundRobin(t *testing.T) {
+	p := newUpstreamPool(startLineServers(t, "a", "b", "c"), testUpstreamOpts)
+
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		c, u, err := p.dial()
+		require.NoError(t, err)
+		counts[greeting(t, c)]++
+		c.Close()
+		p.release(u)
+	}
+	require.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, counts)
+}
+
+func TestLeastConnections(t *testing.T) {
+	opts := testUpstreamOpts
+	opts.Policy = leastConnections
+	p := newUpstreamPool(startLineServers(t, "a", "b", "c"), opts)
+
+	var ups []*upstream
+	var names []string
+	for i := 0; i < 3; i++ {
+		c, u, err := p.dial()
+		require.NoError(t, err)
+		defer c.Close()
+		ups = append(ups, u)
+		names = append(names, greeting(t, c))
+	}
+	require.ElementsMatch(t, []string{"a", "b", "c"}, names)
+
+	// the only upstream without a session is picked regardless of rotation
+	p.release(ups[1])
+	for i := 0; i < 3; i++ {
+		c, u, err := p.dial()
+		require.NoError(t, err)
+		require.Equal(t, names[1], greeting(t, c))
+		c.Close()
+		p.release(u)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	addrs := startLineServers(t, "a", "b")
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downAddr := down.Addr().String()
+	down.Close()
+
+	p := newUpstreamPool([]string{downAddr, addrs[0], addrs[1]}, testUpstreamOpts)
+	for i := 0; i < 4; i++ {
+		c, u, err := p.dial()
+		require.NoError(t, err)
+		require.NotEqual(t, downAddr, u.addr)
+		c.Close()
+		p.release(u)
+	}
+	require.True(t, orderedLast(p, downAddr))
+
+	// comes back and is found by the health check
+	startLineServer(t, "back", downAddr)
+	p.checkHealth()
+	require.False(t, orderedLast(p, downAddr))
+}
+
+func TestHealthCheck(t *testing.T) {
+	l := startLineServer(t, "a", "127.0.0.1:0")
+	addr := l.Addr().String()
+	p := newUpstreamPool([]string{addr, startLineServers(t, "b")[0]}, testUpstreamOpts)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.startHealthChecks(10*time.Millisecond, stop)
+
+	l.Close()
+	require.Eventually(t, func() bool { return orderedLast(p, addr) }, 2*time.Second, 10*time.Millisecond)
+
+	startLineServer(t, "a", addr)
+	require.Eventually(t, func() bool { return !orderedLast(p, addr) }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestProxySessionThroughPool(t *testing.T) {
+	upstreams := newUpstreamPool(startLineServers(t, "Welcome, 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX"), testUpstreamOpts)
+
+	client, proxy := net.Pipe()
+	go handleConnection(proxy, upstreams, lineMode)
+	defer client.Close()
+
+	r := bufio.NewReader(client)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "Welcome, 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n", line)
+
+	fmt.Fprint(client, "pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n")
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n", line)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/protocol"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const (
+	// hex digits, whitespace and colons are ignored
+	formatHex = "hex"
+	// bytes as sent on the wire
+	formatRaw = "raw"
+	// log of the speed server, payloads of its "message size" lines
+	formatLog = "log"
+	// recording lines "<time> <direction> <hex>", > is client to server and < server to client
+	formatRec = "rec"
+)
+
+// description is the JSON form of a message, type is the Go type name, fields are the struct fields
+type description struct {
+	Type   string          `json:"type"`
+	Fields json.RawMessage `json:"fields,omitempty"`
+}
+
+func describe(msg interface{}) (description, error) {
+	fields, err := json.Marshal(msg)
+	if err != nil {
+		return description{}, err
+	}
+	return description{Type: reflect.TypeOf(msg).Name(), Fields: fields}, nil
+}
+
+// stream decodes messages sent in one direction, a message may be split over several chunks
+type stream struct {
+	decoder *messages.Decoder
+	out     io.Writer
+	// printed before messages completed by the current chunk
+	prefix  string
+	pending []byte
+	offset  int
+}
+
+func newStream(out io.Writer, inbound messages.Direction) (*stream, error) {
+	decoder, err := protocol.NewDecoder(inbound)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{decoder: decoder, out: out}, nil
+}
+
+// feed prints complete messages and keeps the bytes of an incomplete one until the next chunk
+func (s *stream) feed(chunk []byte) error {
+	s.pending = append(s.pending, chunk...)
+	for len(s.pending) > 0 {
+		msg, n, err := s.decoder.Unmarshall(s.pending)
+		if errors.Is(err, messages.ErrIncompletePayload) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%sfailed to decode message at offset %d: %w, remaining bytes %s",
+				s.prefix, s.offset, err, hex.EncodeToString(s.pending))
+		}
+
+		d, err := describe(msg)
+		if err != nil {
+			return err
+		}
+		line, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(s.out, "%s%s\n", s.prefix, line)
+		if err != nil {
+			return err
+		}
+
+		s.pending = s.pending[n:]
+		s.offset += n
+	}
+	return nil
+}
+
+// close fails when the stream ends in the middle of a message
+func (s *stream) close() error {
+	if len(s.pending) > 0 {
+		return fmt.Errorf("%sincomplete message at offset %d: %s", s.prefix, s.offset, hex.EncodeToString(s.pending))
+	}
+	return nil
+}
+
+func decodeMessages(r io.Reader, w io.Writer, format string) error {
+	switch format {
+	case formatHex, formatRaw:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if format == formatHex {
+			data, err = parseHex(string(data))
+			if err != nil {
+				return err
+			}
+		}
+		s, err := newStream(w, messages.AnyDirection)
+		if err != nil {
+			return err
+		}
+		err = s.feed(data)
+		if err != nil {
+			return err
+		}
+		return s.close()
+	case formatLog:
+		return decodeLog(r, w)
+	case formatRec:
+		return decodeRecording(r, w)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func parseHex(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ':' {
+			return -1
+		}
+		return r
+	}, s)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+	return b, nil
+}
+
+// decodeLog decodes payloads logged by a server started with -log-payloads, it only logs messages sent by clients
+func decodeLog(r io.Reader, w io.Writer) error {
+	s, err := newStream(w, messages.ClientToServer)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		_, payload, ok := strings.Cut(scanner.Text(), "payload: ")
+		if !ok {
+			continue
+		}
+		b, err := parseHex(payload)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		err = s.feed(b)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return s.close()
+}
+
+// decodeRecording decodes both directions of a recorded connection, each one as a stream of its own
+func decodeRecording(r io.Reader, w io.Writer) error {
+	streams := map[string]*stream{}
+	for dir, inbound := range map[string]messages.Direction{">": messages.ClientToServer, "<": messages.ServerToClient} {
+		s, err := newStream(w, inbound)
+		if err != nil {
+			return err
+		}
+		streams[dir] = s
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return fmt.Errorf("line %d: want <time> <direction> <hex>, got %q", n, line)
+		}
+		s, ok := streams[fields[1]]
+		if !ok {
+			return fmt.Errorf("line %d: unknown direction %q, want > or <", n, fields[1])
+		}
+		b, err := parseHex(strings.Join(fields[2:], ""))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		s.prefix = fields[0] + " " + fields[1] + " "
+		err = s.feed(b)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, dir := range []string{">", "<"} {
+		err := streams[dir].close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
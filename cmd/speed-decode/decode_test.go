@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	cameraHex      = "8000420064003c"
+	measurementHex = "2004554e3158000003e8"
+	heartbeatHex   = "41"
+
+	cameraJSON    = `{"type":"IAmCameraMsg","fields":{"Road":66,"Mile":100,"Limit":60}}`
+	heartbeatJSON = `{"type":"HearbeatSignal","fields":{}}`
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   string
+	}{
+		{"hex", formatHex, cameraHex + heartbeatHex, cameraJSON + "\n" + heartbeatJSON + "\n"},
+		{"hex with spaces and colons", formatHex, "80 00:42\n00 64 00 3c", cameraJSON + "\n"},
+		{"raw", formatRaw, "\x80\x00\x42\x00\x64\x00\x3c", cameraJSON + "\n"},
+		{"empty", formatHex, "", ""},
+		{
+			"log",
+			formatLog,
+			"accepted connection\nmessage size: 7, payload: " + cameraHex + "\nmessage size: 7, payload: " + cameraHex + "\n",
+			cameraJSON + "\n" + cameraJSON + "\n",
+		},
+		{
+			"recording",
+			formatRec,
+			"# camera connection\n10:00:00.000 > 8000420064\n\n10:00:00.100 < " + heartbeatHex + "\n10:00:00.200 > 003c\n",
+			"10:00:00.100 < " + heartbeatJSON + "\n10:00:00.200 > " + cameraJSON + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := decodeMessages(strings.NewReader(tt.input), &out, tt.format)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestDecodeMeasurement(t *testing.T) {
+	var out bytes.Buffer
+	err := decodeMessages(strings.NewReader(measurementHex), &out, formatHex)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), `{"type":"MeasurementTimeMsg","fields":{"Plate":"UN1X","Timestamp":"1970-01-01T`)
+}
+
+func TestDecodeFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		err    string
+	}{
+		{"unknown message", formatHex, cameraHex + "99", "at offset 7: message type (153) not registered, remaining bytes 99"},
+		{"incomplete message", formatHex, cameraHex + "800042", "incomplete message at offset 7: 800042"},
+		{"invalid hex", formatHex, "8g", "invalid hex"},
+		{"unknown format", "pcap", "", `unknown format "pcap"`},
+		{"server message in log", formatLog, "message size: 1, payload: 41\n", "line 1: failed to decode message at offset 0"},
+		{"wrong direction", formatRec, "10:00 < " + cameraHex + "\n", "line 1: 10:00 < failed to decode message"},
+		{"unknown direction", formatRec, "10:00 = " + cameraHex + "\n", `line 1: unknown direction "="`},
+		{"missing hex", formatRec, "10:00 >\n", "line 1: want <time> <direction> <hex>"},
+		{"incomplete recording", formatRec, "10:00 > 8000\n", "10:00 > incomplete message at offset 0: 8000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := decodeMessages(strings.NewReader(tt.input), &out, tt.format)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/protocol"
+	"reflect"
+)
+
+// encodeMessages writes payloads of messages described by JSON, one hex line per message or the bytes of all of them
+func encodeMessages(r io.Reader, w io.Writer, format string) error {
+	if format != formatHex && format != formatRaw {
+		return fmt.Errorf("cannot encode to format %q, want hex or raw", format)
+	}
+	decoder, err := protocol.NewDecoder(messages.AnyDirection)
+	if err != nil {
+		return err
+	}
+	types := messageTypes(decoder)
+
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var d description
+		err := dec.Decode(&d)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("message %d: %w", n, err)
+		}
+
+		payload, err := d.encode(decoder, types)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", n, err)
+		}
+		if format == formatHex {
+			_, err = fmt.Fprintln(w, hex.EncodeToString(payload))
+		} else {
+			_, err = w.Write(payload)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// messageTypes indexes registrations by type name, qualified type name and code, e.g. IAmCameraMsg,
+// tracking.IAmCameraMsg and 0x80
+func messageTypes(decoder *messages.Decoder) map[string]messages.Registration {
+	types := map[string]messages.Registration{}
+	for _, r := range decoder.Registrations() {
+		types[r.Type.Name()] = r
+		types[r.Type.String()] = r
+		types[fmt.Sprintf("0x%02x", byte(r.MsgType))] = r
+	}
+	return types
+}
+
+func (d description) encode(decoder *messages.Decoder, types map[string]messages.Registration) ([]byte, error) {
+	r, ok := types[d.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %q", d.Type)
+	}
+
+	msg := reflect.New(r.Type)
+	if len(d.Fields) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(d.Fields))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(msg.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("invalid fields of %s: %w", d.Type, err)
+		}
+	}
+	return decoder.Marshal(msg.Elem().Interface())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   string
+	}{
+		{"type name", formatHex, cameraJSON, cameraHex + "\n"},
+		{"qualified type name", formatHex, `{"type":"tracking.IAmCameraMsg","fields":{"Road":66,"Mile":100,"Limit":60}}`, cameraHex + "\n"},
+		{"code", formatHex, `{"type":"0x80","fields":{"Road":66,"Mile":100,"Limit":60}}`, cameraHex + "\n"},
+		{"no fields", formatHex, `{"type":"HearbeatSignal"}`, heartbeatHex + "\n"},
+		{"server and client messages", formatHex, cameraJSON + "\n" + heartbeatJSON, cameraHex + "\n" + heartbeatHex + "\n"},
+		{"raw", formatRaw, cameraJSON + heartbeatJSON, "\x80\x00\x42\x00\x64\x00\x3c\x41"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := encodeMessages(strings.NewReader(tt.input), &out, tt.format)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	input := `{"type":"TicketMsg","fields":{"Plate":"UN1X","Road":66,"Mile1":100,"Timestamp1":"2022-11-20T10:00:00Z",` +
+		`"Mile2":110,"Timestamp2":"2022-11-20T10:05:00Z","Speed":12000}}`
+
+	var encoded bytes.Buffer
+	err := encodeMessages(strings.NewReader(input), &encoded, formatRaw)
+	require.NoError(t, err)
+
+	var decoded bytes.Buffer
+	err = decodeMessages(&encoded, &decoded, formatRaw)
+	require.NoError(t, err)
+
+	var reencoded bytes.Buffer
+	err = encodeMessages(&decoded, &reencoded, formatHex)
+	require.NoError(t, err)
+	require.Equal(t, "2104554e3158004200646379faa0006e6379fbcc2ee0\n", reencoded.String())
+}
+
+func TestEncodeFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		err    string
+	}{
+		{"unknown type", formatHex, `{"type":"Pong"}`, `message 1: unknown message type "Pong"`},
+		{"unknown field", formatHex, `{"type":"IAmCameraMsg","fields":{"Speed":1}}`, `message 1: invalid fields of IAmCameraMsg`},
+		{"invalid field", formatHex, `{"type":"IAmCameraMsg","fields":{"Road":-1}}`, `message 1: invalid fields of IAmCameraMsg`},
+		{"invalid json", formatHex, cameraJSON + `{"type":`, "message 2:"},
+		{"log output", formatLog, cameraJSON, `cannot encode to format "log"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := encodeMessages(strings.NewReader(tt.input), &out, tt.format)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
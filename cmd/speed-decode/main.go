@@ -0,0 +1,50 @@
+// speed-decode prints speed protocol messages of hex dumps, raw bytes, server logs or recordings as JSON lines,
+// with -encode it builds payloads from the same JSON lines
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	format = formatHex
+	input  = ""
+	encode = false
+)
+
+func main() {
+	flag.StringVar(&format, "format", format, "format of decoded input or encoded output: hex, raw, log or rec")
+	flag.StringVar(&input, "input", input, "file to read, stdin when empty")
+	flag.BoolVar(&encode, "encode", encode, "encode JSON messages read from input instead of decoding")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	switch {
+	case input != "":
+		f, err := os.Open(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	case flag.NArg() > 0:
+		// hex given as arguments
+		in = strings.NewReader(strings.Join(flag.Args(), " "))
+	}
+
+	var err error
+	if encode {
+		err = encodeMessages(in, os.Stdout, format)
+	} else {
+		err = decodeMessages(in, os.Stdout, format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
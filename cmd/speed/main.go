@@ -1,17 +1,19 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"max-mulawa/echo/cmd/speed/messages"
 	"max-mulawa/echo/cmd/speed/ops"
+	"max-mulawa/echo/cmd/speed/protocol"
 	"max-mulawa/echo/cmd/speed/ticketing"
 	"max-mulawa/echo/cmd/speed/tracking"
 	"max-mulawa/echo/cmd/speed/traffic"
 	"net"
 	"os"
-	"reflect"
-	"strings"
 	"time"
 )
 
@@ -24,9 +26,14 @@ var (
 	offenceFeed     = traffic.NewOffenseFeed(offenseSub)
 	dispatchers     = ticketing.NewRoadDispatchers()
 	measurementsReg = traffic.NewMeasurementsRegistry(offenceFeed)
+
+	logPayloads = false
 )
 
 func main() {
+	flag.BoolVar(&logPayloads, "log-payloads", logPayloads, "print every message received from clients as hex, speed-decode -format log reads them")
+	flag.Parse()
+
 	startServer()
 }
 
@@ -71,19 +78,18 @@ func handleConnection(conn net.Conn) {
 		conn.Close()
 	}()
 
-	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{}))
-	decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{}))
-
-	decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{}))
-	decoder.RegisterMsg(ticketing.TicketMsgType, reflect.TypeOf(ticketing.TicketMsg{}))
-
-	decoder.RegisterMsg(ops.HeartbeatRequestMsgType, reflect.TypeOf(ops.HeartbeatRequest{}))
-	decoder.RegisterMsg(ops.HeartbeatMsgType, reflect.TypeOf(ops.HearbeatSignal{}))
-
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
+	decoder, err := protocol.NewDecoder(messages.ClientToServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	reader := messages.NewReader(conn, decoder)
+	if logPayloads {
+		reader.OnPayload(func(payload []byte) {
+			fmt.Printf("message size: %d, payload: %s\n", len(payload), hex.EncodeToString(payload))
+		})
+	}
 	var msgHanlder Handler
 	var hearbeatHandler Handler
 	var dispatcher *ticketing.Dispatcher
@@ -124,8 +130,10 @@ func handleConnection(conn net.Conn) {
 			msgHanlder = NewDispatcherHandler(dispatcher)
 		case error:
 			fmt.Println("error occured on message dispatching: ", m)
-			if strings.Contains(m.Error(), "not registered") {
+			if errors.Is(m, messages.ErrNotRegistered) {
 				writeServerError(conn, decoder, "unknown message")
+			} else if errors.Is(m, messages.ErrWrongDirection) {
+				writeServerError(conn, decoder, "message sent by server only")
 			} else if m != messages.ErrClientClosed {
 				writeServerError(conn, decoder, fmt.Sprintf("failuire occured in dispatching messages: %v", m))
 			}
@@ -25,7 +25,7 @@ func TestRegisterCamera(t *testing.T) {
 	defer camera.Close()
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{}))
+	require.NoError(t, decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{})))
 
 	payload, err := decoder.Marshal(tracking.IAmCameraMsg{
 		Road:  124,
@@ -42,7 +42,7 @@ func TestRegisterDispatcher(t *testing.T) {
 	defer conn.Close()
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{}))
+	require.NoError(t, decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{})))
 
 	payload, err := decoder.Marshal(ticketing.IAmDispatcherMsg{
 		Roads: []uint16{8, 125, 4},
@@ -56,8 +56,8 @@ func TestRegisterCameraTwice(t *testing.T) {
 	defer camera.Close()
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{}))
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
+	require.NoError(t, decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{})))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
 
 	payload, err := decoder.Marshal(tracking.IAmCameraMsg{
 		Road:  126,
@@ -85,8 +85,8 @@ func TestRegisterDispatcherTwice(t *testing.T) {
 	defer camera.Close()
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{}))
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
+	require.NoError(t, decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{})))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
 
 	payload, err := decoder.Marshal(ticketing.IAmDispatcherMsg{
 		Roads: []uint16{126},
@@ -115,10 +115,10 @@ func TestRegisterCameraSendMeasurementsAndWaitForTicket(t *testing.T) {
 	speedLimit := uint16(60)
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{}))
-	decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{}))
-	decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{}))
-	decoder.RegisterMsg(ticketing.TicketMsgType, reflect.TypeOf(ticketing.TicketMsg{}))
+	require.NoError(t, decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{})))
+	require.NoError(t, decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{})))
+	require.NoError(t, decoder.RegisterMsg(ticketing.IAmDispatcherMsgType, reflect.TypeOf(ticketing.IAmDispatcherMsg{})))
+	require.NoError(t, decoder.RegisterMsg(ticketing.TicketMsgType, reflect.TypeOf(ticketing.TicketMsg{})))
 
 	// register cameras
 	registerCamera1, err := decoder.Marshal(tracking.IAmCameraMsg{
@@ -180,9 +180,9 @@ func TestMeasurementWithInvalidNumberPlate(t *testing.T) {
 	speedLimit := uint16(70)
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{}))
-	decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{}))
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
+	require.NoError(t, decoder.RegisterMsg(tracking.IAmCameraMsgType, reflect.TypeOf(tracking.IAmCameraMsg{})))
+	require.NoError(t, decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{})))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
 
 	// register cameras
 	registerCamera1, err := decoder.Marshal(tracking.IAmCameraMsg{
@@ -215,8 +215,8 @@ func TestUnknownMessageType(t *testing.T) {
 	}
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(messages.MsgType(5), reflect.TypeOf(stringTest{}))
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
+	require.NoError(t, decoder.RegisterMsg(messages.MsgType(5), reflect.TypeOf(stringTest{})))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
 
 	unknownMsg, err := decoder.Marshal(stringTest{
 		Msg: "i'm unknown type",
@@ -236,10 +236,27 @@ func TestUnknownMessageType(t *testing.T) {
 	require.Contains(t, srvErr.Msg, "unknown")
 }
 
+func TestServerMessageSentByClient(t *testing.T) {
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterCodec(ticketing.TicketMsgType, ticketing.TicketMsgCodec))
+	require.NoError(t, decoder.RegisterCodec(ops.ErrorMsgType, ops.ServerErrorCodec))
+
+	ticket, err := decoder.Marshal(ticketing.TicketMsg{Plate: "UN1X", Road: 66})
+	require.NoError(t, err)
+
+	client := Connect(t)
+	defer client.Close()
+	Write(t, client, ticket)
+
+	msgs := messages.NewReader(client, decoder).GetMessages()
+	srvErr := (<-msgs).(ops.ServerError)
+	require.Contains(t, srvErr.Msg, "sent by server")
+}
+
 func TestUnorderedMessages(t *testing.T) {
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
-	decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{}))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
+	require.NoError(t, decoder.RegisterMsg(tracking.MeasurementTimeMsgType, reflect.TypeOf(tracking.MeasurementTimeMsg{})))
 
 	measurementMsg, err := decoder.Marshal(tracking.MeasurementTimeMsg{
 		Plate:     "ABC123",
@@ -260,9 +277,9 @@ func TestUnorderedMessages(t *testing.T) {
 
 func TestHearbeatMessages(t *testing.T) {
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
-	decoder.RegisterMsg(ops.HeartbeatRequestMsgType, reflect.TypeOf(ops.HeartbeatRequest{}))
-	decoder.RegisterMsg(ops.HeartbeatMsgType, reflect.TypeOf(ops.HearbeatSignal{}))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
+	require.NoError(t, decoder.RegisterMsg(ops.HeartbeatRequestMsgType, reflect.TypeOf(ops.HeartbeatRequest{})))
+	require.NoError(t, decoder.RegisterMsg(ops.HeartbeatMsgType, reflect.TypeOf(ops.HearbeatSignal{})))
 
 	heartbeatReq, err := decoder.Marshal(ops.HeartbeatRequest{
 		Interval: 3,
@@ -310,7 +327,7 @@ func TestSampleSession(t *testing.T) {
 	Write(t, dispatcher, registerDispatcher)
 
 	decoder := messages.NewDecoder()
-	decoder.RegisterMsg(ticketing.TicketMsgType, reflect.TypeOf(ticketing.TicketMsg{}))
+	require.NoError(t, decoder.RegisterMsg(ticketing.TicketMsgType, reflect.TypeOf(ticketing.TicketMsg{})))
 	reader := messages.NewReader(dispatcher, decoder)
 	msgs := reader.GetMessages()
 	ticket := (<-msgs).(ticketing.TicketMsg)
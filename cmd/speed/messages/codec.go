@@ -0,0 +1,168 @@
+package messages
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Codec encodes and decodes one message type without reflection, codecs are generated by codecgen.
+// Payloads passed to Decode and appended by Append exclude the message type byte
+type Codec struct {
+	Type   reflect.Type
+	Append func(b []byte, msg interface{}) ([]byte, error)
+	Decode func(payload []byte) (interface{}, int, error)
+}
+
+// WireReader reads fields of a payload in order, once a read runs past the payload it keeps returning zero values
+// and Err reports ErrIncompletePayload
+type WireReader struct {
+	payload []byte
+	offset  int
+	err     error
+}
+
+func NewWireReader(payload []byte) WireReader {
+	return WireReader{payload: payload}
+}
+
+func (r *WireReader) Err() error {
+	return r.err
+}
+
+// Offset is the number of bytes read so far
+func (r *WireReader) Offset() int {
+	return r.offset
+}
+
+func (r *WireReader) next(n int) []byte {
+	if r.err != nil || len(r.payload)-r.offset < n {
+		r.err = ErrIncompletePayload
+		return nil
+	}
+	b := r.payload[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *WireReader) Uint8() uint8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *WireReader) Uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *WireReader) Uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// String8 reads a string prefixed with its u8 length
+func (r *WireReader) String8() string {
+	b := r.next(int(r.Uint8()))
+	if b == nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Time32 reads u32 seconds since the epoch
+func (r *WireReader) Time32() time.Time {
+	v := r.Uint32()
+	if r.err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(v), 0)
+}
+
+// Uint16Array8 reads u16 values prefixed with their u8 count
+func (r *WireReader) Uint16Array8() []uint16 {
+	n := int(r.Uint8())
+	b := r.next(n * 2)
+	if b == nil {
+		return nil
+	}
+	v := make([]uint16, n)
+	for i := range v {
+		v[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return v
+}
+
+// WireWriter appends fields of a payload in order, once a value does not fit its length prefix nothing more is
+// appended and Err reports ErrValueTooLong
+type WireWriter struct {
+	b   []byte
+	err error
+}
+
+func NewWireWriter(b []byte) WireWriter {
+	return WireWriter{b: b}
+}
+
+func (w *WireWriter) Err() error {
+	return w.err
+}
+
+// Bytes is the payload appended so far
+func (w *WireWriter) Bytes() []byte {
+	return w.b
+}
+
+func (w *WireWriter) Uint8(v uint8) {
+	if w.err == nil {
+		w.b = append(w.b, v)
+	}
+}
+
+func (w *WireWriter) Uint16(v uint16) {
+	if w.err == nil {
+		w.b = append(w.b, byte(v>>8), byte(v))
+	}
+}
+
+func (w *WireWriter) Uint32(v uint32) {
+	if w.err == nil {
+		w.b = append(w.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// String8 writes the u8 length and the string
+func (w *WireWriter) String8(v string) {
+	if w.err == nil && len(v) > math.MaxUint8 {
+		w.err = fmt.Errorf("string of %d bytes: %w", len(v), ErrValueTooLong)
+	}
+	w.Uint8(uint8(len(v)))
+	if w.err == nil {
+		w.b = append(w.b, v...)
+	}
+}
+
+func (w *WireWriter) Time32(v time.Time) {
+	w.Uint32(uint32(v.Unix()))
+}
+
+// Uint16Array8 writes the u8 count and the values
+func (w *WireWriter) Uint16Array8(v []uint16) {
+	if w.err == nil && len(v) > math.MaxUint8 {
+		w.err = fmt.Errorf("array of %d values: %w", len(v), ErrValueTooLong)
+	}
+	w.Uint8(uint8(len(v)))
+	for _, e := range v {
+		w.Uint16(e)
+	}
+}
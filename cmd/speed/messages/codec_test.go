@@ -0,0 +1,107 @@
+package messages_test
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/ops"
+	"max-mulawa/echo/cmd/speed/ticketing"
+	"max-mulawa/echo/cmd/speed/tracking"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testTicket = ticketing.TicketMsg{
+		Plate:      "UN1X",
+		Road:       66,
+		Mile1:      100,
+		Timestamp1: time.Unix(123456, 0),
+		Mile2:      110,
+		Timestamp2: time.Unix(123816, 0),
+		Speed:      10000,
+	}
+
+	codecMessages = []struct {
+		msgType messages.MsgType
+		codec   messages.Codec
+		msg     interface{}
+	}{
+		{tracking.IAmCameraMsgType, tracking.IAmCameraMsgCodec, tracking.IAmCameraMsg{Road: 66, Mile: 100, Limit: 60}},
+		{tracking.MeasurementTimeMsgType, tracking.MeasurementTimeMsgCodec, tracking.MeasurementTimeMsg{Plate: "UN1X", Timestamp: time.Unix(123456, 0)}},
+		{ticketing.TicketMsgType, ticketing.TicketMsgCodec, testTicket},
+		{ticketing.IAmDispatcherMsgType, ticketing.IAmDispatcherMsgCodec, ticketing.IAmDispatcherMsg{Roads: []uint16{66, 368, 5000}}},
+		{ops.HeartbeatRequestMsgType, ops.HeartbeatRequestCodec, ops.HeartbeatRequest{Interval: 10}},
+		{ops.HeartbeatMsgType, ops.HearbeatSignalCodec, ops.HearbeatSignal{}},
+		{ops.ErrorMsgType, ops.ServerErrorCodec, ops.ServerError{Msg: "bad"}},
+	}
+)
+
+func TestCodecsMatchReflection(t *testing.T) {
+	for _, tc := range codecMessages {
+		t.Run(tc.codec.Type.Name(), func(t *testing.T) {
+			reflection := messages.NewDecoder()
+			require.NoError(t, reflection.RegisterMsg(tc.msgType, tc.codec.Type))
+			generated := messages.NewDecoder()
+			require.NoError(t, generated.RegisterCodec(tc.msgType, tc.codec))
+
+			expected, err := reflection.Marshal(tc.msg)
+			require.NoError(t, err)
+			payload, err := generated.Marshal(tc.msg)
+			require.NoError(t, err)
+			require.Equal(t, expected, payload)
+
+			msg, n, err := generated.Unmarshall(append(payload, 0xff))
+			require.NoError(t, err)
+			require.Equal(t, len(payload), n)
+			require.Equal(t, tc.msg, msg)
+
+			if len(payload) > 1 {
+				msg, n, err = generated.Unmarshall(payload[:len(payload)-1])
+				require.Equal(t, messages.ErrIncompletePayload, err)
+				require.Nil(t, msg)
+				require.Equal(t, 0, n)
+			}
+		})
+	}
+}
+
+func benchmarkDecoders(b *testing.B) map[string]*messages.Decoder {
+	reflection := messages.NewDecoder()
+	require.NoError(b, reflection.RegisterMsg(ticketing.TicketMsgType, reflect.TypeOf(ticketing.TicketMsg{})))
+	generated := messages.NewDecoder()
+	require.NoError(b, generated.RegisterCodec(ticketing.TicketMsgType, ticketing.TicketMsgCodec))
+	return map[string]*messages.Decoder{"reflection": reflection, "codec": generated}
+}
+
+func BenchmarkUnmarshall(b *testing.B) {
+	for name, decoder := range benchmarkDecoders(b) {
+		payload, err := decoder.Marshal(testTicket)
+		require.NoError(b, err)
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _, err := decoder.Unmarshall(payload)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for name, decoder := range benchmarkDecoders(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := decoder.Marshal(testTicket)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
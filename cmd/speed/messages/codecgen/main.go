@@ -0,0 +1,224 @@
+// codecgen writes messages.Codec implementations for speed message structs, it is run by go generate
+// in the package declaring the messages:
+//
+//	//go:generate go run max-mulawa/echo/cmd/speed/messages/codecgen -types IAmCameraMsg,MeasurementTimeMsg
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var (
+	dir      = "."
+	typeList = ""
+	output   = "codec_gen.go"
+)
+
+// wireFuncs names WireReader and WireWriter methods of messages by the Go type of a field
+var wireFuncs = map[string]string{
+	"uint8":     "Uint8",
+	"byte":      "Uint8",
+	"uint16":    "Uint16",
+	"uint32":    "Uint32",
+	"string":    "String8",
+	"time.Time": "Time32",
+	"[]uint16":  "Uint16Array8",
+}
+
+type field struct {
+	Name string
+	Wire string
+}
+
+type msgType struct {
+	Name   string
+	Fields []field
+}
+
+var codecTemplate = template.Must(template.New("codec").Parse(`// Code generated by codecgen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"reflect"
+)
+{{range .Types}}
+// {{.Name}}Codec encodes and decodes {{.Name}} without reflection
+var {{.Name}}Codec = messages.Codec{
+	Type: reflect.TypeOf({{.Name}}{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return append{{.Name}}(b, msg.({{.Name}}))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decode{{.Name}}(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func append{{.Name}}(b []byte, m {{.Name}}) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+{{- range .Fields}}
+	w.{{.Wire}}(m.{{.Name}})
+{{- end}}
+	return w.Bytes(), w.Err()
+}
+
+func decode{{.Name}}(payload []byte) ({{.Name}}, int, error) {
+	r := messages.NewWireReader(payload)
+	var m {{.Name}}
+{{- range .Fields}}
+	m.{{.Name}} = r.{{.Wire}}()
+{{- end}}
+	if r.Err() != nil {
+		return {{.Name}}{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
+{{end -}}
+`))
+
+func main() {
+	flag.StringVar(&dir, "dir", dir, "directory of the package declaring the messages")
+	flag.StringVar(&typeList, "types", typeList, "comma separated message structs to generate codecs for")
+	flag.StringVar(&output, "output", output, "generated file name, relative to -dir")
+	flag.Parse()
+
+	if typeList == "" {
+		fmt.Fprintln(os.Stderr, "codecgen: no -types given")
+		os.Exit(2)
+	}
+
+	src, err := generate(dir, strings.Split(typeList, ","))
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, output), src, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codecgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// generate returns formatted source of codecs of the named structs declared in dir
+func generate(dir string, names []string) ([]byte, error) {
+	pkg, structs, err := parseStructs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var types []msgType
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		st, ok := structs[name]
+		if !ok {
+			return nil, fmt.Errorf("struct %s not found in %s", name, dir)
+		}
+		t, err := newMsgType(name, st)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+
+	var buf bytes.Buffer
+	err = codecTemplate.Execute(&buf, struct {
+		Package string
+		Types   []msgType
+	}{pkg, types})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// parseStructs reads struct declarations of the package in dir skipping tests and the generated file
+func parseStructs(dir string) (string, map[string]*ast.StructType, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(files)
+
+	pkg := ""
+	structs := map[string]*ast.StructType{}
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") || filepath.Base(path) == output {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		pkg = f.Name.Name
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			if st, ok := ts.Type.(*ast.StructType); ok {
+				structs[ts.Name.Name] = st
+			}
+			return false
+		})
+	}
+	if pkg == "" {
+		return "", nil, fmt.Errorf("no go files in %s", dir)
+	}
+	return pkg, structs, nil
+}
+
+func newMsgType(name string, st *ast.StructType) (msgType, error) {
+	t := msgType{Name: name}
+	for _, f := range st.Fields.List {
+		if f.Tag != nil && strings.Contains(f.Tag.Value, "wire:") {
+			return t, fmt.Errorf("%s: wire tags are not supported, register it with RegisterMsg", name)
+		}
+		typeName := exprString(f.Type)
+		wire, ok := wireFuncs[typeName]
+		if !ok || len(f.Names) == 0 {
+			return t, fmt.Errorf("%s: field of type %s is not supported", name, typeName)
+		}
+		for _, n := range f.Names {
+			t.Fields = append(t.Fields, field{Name: n.Name, Wire: wire})
+		}
+	}
+	return t, nil
+}
+
+func exprString(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return exprString(t.X) + "." + t.Sel.Name
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return fmt.Sprintf("[%s]%s", exprString(t.Len), exprString(t.Elt))
+		}
+		return "[]" + exprString(t.Elt)
+	case *ast.BasicLit:
+		return t.Value
+	default:
+		return fmt.Sprintf("%T", e)
+	}
+}
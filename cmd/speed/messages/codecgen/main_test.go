@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedCodecsUpToDate(t *testing.T) {
+	for _, tc := range []struct {
+		dir   string
+		types []string
+	}{
+		{dir: "../../tracking", types: []string{"IAmCameraMsg", "MeasurementTimeMsg"}},
+		{dir: "../../ticketing", types: []string{"TicketMsg", "IAmDispatcherMsg"}},
+		{dir: "../../ops", types: []string{"HeartbeatRequest", "HearbeatSignal", "ServerError"}},
+	} {
+		t.Run(tc.dir, func(t *testing.T) {
+			src, err := generate(tc.dir, tc.types)
+			require.NoError(t, err)
+			current, err := os.ReadFile(filepath.Join(tc.dir, output))
+			require.NoError(t, err)
+			require.Equal(t, string(current), string(src), "run go generate ./cmd/speed/...")
+		})
+	}
+}
+
+func TestUnsupportedFields(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "msg.go"), []byte("package msg\n\ntype Msg struct {\n\tValue float64\n}\n"), 0o644)
+	require.NoError(t, err)
+
+	_, err = generate(dir, []string{"Msg"})
+	require.ErrorContains(t, err, "float64 is not supported")
+	_, err = generate(dir, []string{"Missing"})
+	require.ErrorContains(t, err, "not found")
+
+	err = os.WriteFile(filepath.Join(dir, "msg.go"), []byte("package msg\n\ntype Msg struct {\n\tValue uint32 `wire:\"u16\"`\n}\n"), 0o644)
+	require.NoError(t, err)
+	_, err = generate(dir, []string{"Msg"})
+	require.ErrorContains(t, err, "wire tags")
+}
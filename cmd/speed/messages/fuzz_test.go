@@ -0,0 +1,209 @@
+package messages_test
+
+import (
+	"io"
+	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/ticketing"
+	"max-mulawa/echo/cmd/speed/tracking"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fuzzDecoders returns decoders of all speed messages, one with generated codecs and one using reflection
+func fuzzDecoders(t testing.TB) (*messages.Decoder, *messages.Decoder) {
+	generated := messages.NewDecoder()
+	reflection := messages.NewDecoder()
+	for _, m := range codecMessages {
+		require.NoError(t, generated.RegisterCodec(m.msgType, m.codec))
+		require.NoError(t, reflection.RegisterMsg(m.msgType, m.codec.Type))
+	}
+	return generated, reflection
+}
+
+func seedPayloads(t testing.TB) [][]byte {
+	generated, _ := fuzzDecoders(t)
+	var seeds [][]byte
+	for _, m := range codecMessages {
+		payload, err := generated.Marshal(m.msg)
+		require.NoError(t, err)
+		seeds = append(seeds, payload)
+	}
+	return seeds
+}
+
+func FuzzUnmarshall(f *testing.F) {
+	for _, seed := range seedPayloads(f) {
+		f.Add(seed)
+		f.Add(seed[:len(seed)/2])
+	}
+	f.Add([]byte{byte(ticketing.IAmDispatcherMsgType), 0xff, 0x00, 0x01})
+
+	generated, reflection := fuzzDecoders(f)
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		msg, n, err := generated.Unmarshall(payload)
+		reflectMsg, reflectN, reflectErr := reflection.Unmarshall(payload)
+		require.Equal(t, reflectErr, err)
+		require.Equal(t, reflectN, n)
+		require.Equal(t, reflectMsg, msg)
+		if err != nil {
+			require.Nil(t, msg)
+			require.Equal(t, 0, n)
+			return
+		}
+
+		require.LessOrEqual(t, n, len(payload))
+		encoded, err := generated.Marshal(msg)
+		require.NoError(t, err)
+		require.Equal(t, payload[:n], encoded)
+	})
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add("UN1X", uint16(66), uint16(100), uint32(123456), uint16(110), uint32(123816), uint16(10000), []byte{0, 66, 1, 112})
+	f.Add("", uint16(0), uint16(0), uint32(0), uint16(0), uint32(0), uint16(0), []byte{})
+	f.Add(strings.Repeat("x", 300), uint16(1), uint16(2), uint32(3), uint16(4), uint32(5), uint16(6), make([]byte, 600))
+
+	generated, reflection := fuzzDecoders(f)
+	f.Fuzz(func(t *testing.T, plate string, road uint16, mile1 uint16, ts1 uint32, mile2 uint16, ts2 uint32, speed uint16, roadBytes []byte) {
+		roads := make([]uint16, len(roadBytes)/2)
+		for i := range roads {
+			roads[i] = uint16(roadBytes[i*2])<<8 | uint16(roadBytes[i*2+1])
+		}
+
+		for _, tc := range []struct {
+			msg     interface{}
+			tooLong bool
+		}{
+			{
+				msg:     ticketing.TicketMsg{Plate: plate, Road: road, Mile1: mile1, Timestamp1: time.Unix(int64(ts1), 0), Mile2: mile2, Timestamp2: time.Unix(int64(ts2), 0), Speed: speed},
+				tooLong: len(plate) > 255,
+			},
+			{
+				msg:     tracking.MeasurementTimeMsg{Plate: plate, Timestamp: time.Unix(int64(ts1), 0)},
+				tooLong: len(plate) > 255,
+			},
+			{
+				msg:     ticketing.IAmDispatcherMsg{Roads: roads},
+				tooLong: len(roads) > 255,
+			},
+		} {
+			payload, err := generated.Marshal(tc.msg)
+			_, reflectErr := reflection.Marshal(tc.msg)
+			if tc.tooLong {
+				// a cut length prefix would corrupt the frame
+				require.ErrorIs(t, err, messages.ErrValueTooLong)
+				require.ErrorIs(t, reflectErr, messages.ErrValueTooLong)
+				continue
+			}
+			require.NoError(t, err)
+			reflectPayload, err := reflection.Marshal(tc.msg)
+			require.NoError(t, err)
+			require.Equal(t, reflectPayload, payload)
+
+			msg, n, err := generated.Unmarshall(payload)
+			require.NoError(t, err)
+			require.Equal(t, len(payload), n)
+			require.Equal(t, tc.msg, msg)
+		}
+	})
+}
+
+func FuzzGetMessages(f *testing.F) {
+	seeds := seedPayloads(f)
+	var stream []byte
+	for _, seed := range seeds {
+		stream = append(stream, seed...)
+	}
+	f.Add(stream, []byte{0})
+	f.Add(stream, []byte{3, 0, 17, 255})
+	f.Add(append(stream, seeds[0][:2]...), []byte{})
+	f.Add(append(stream, 0x99), []byte{1})
+
+	generated, _ := fuzzDecoders(f)
+	f.Fuzz(func(t *testing.T, stream []byte, chunks []byte) {
+		expected, expectedErr := decodeAll(generated, stream)
+
+		var got []interface{}
+		var gotErr error
+		r := messages.NewReader(&chunkedReader{data: stream, chunks: chunks}, generated)
+		for m := range r.GetMessages() {
+			if err, ok := m.(error); ok {
+				gotErr = err
+				continue
+			}
+			got = append(got, m)
+		}
+
+		require.Equal(t, expected, got)
+		if expectedErr == nil {
+			require.Equal(t, messages.ErrClientClosed, gotErr)
+		} else {
+			require.ErrorContains(t, gotErr, expectedErr.Error())
+		}
+
+		// a buffer smaller than most messages wraps around and grows
+		got = nil
+		r = messages.NewReaderSize(&chunkedReader{data: stream, chunks: chunks}, generated, 5)
+		for {
+			msg, err := r.Next()
+			if err != nil {
+				gotErr = err
+				break
+			}
+			got = append(got, msg)
+		}
+		require.Equal(t, expected, got)
+		if expectedErr == nil {
+			require.Equal(t, messages.ErrClientClosed, gotErr)
+		} else {
+			require.ErrorContains(t, gotErr, expectedErr.Error())
+		}
+	})
+}
+
+// decodeAll decodes the whole stream at once, it returns the error stopping it unless it ends in an incomplete message
+func decodeAll(d *messages.Decoder, stream []byte) ([]interface{}, error) {
+	var msgs []interface{}
+	for len(stream) > 0 {
+		msg, n, err := d.Unmarshall(stream)
+		if err == messages.ErrIncompletePayload {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+		stream = stream[n:]
+	}
+	return msgs, nil
+}
+
+// chunkedReader returns data in chunks of sizes read from chunks, 1 to 256 bytes each, the rest comes in one read
+type chunkedReader struct {
+	data   []byte
+	chunks []byte
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := len(r.data)
+	if len(r.chunks) > 0 {
+		n = minInt(n, int(r.chunks[0])+1)
+		r.chunks = r.chunks[1:]
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
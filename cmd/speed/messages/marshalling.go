@@ -1,8 +1,6 @@
 package messages
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"reflect"
 	"time"
@@ -10,158 +8,67 @@ import (
 
 var (
 	ErrIncompletePayload = fmt.Errorf("incomplete payload")
+	ErrValueTooLong      = fmt.Errorf("value longer than its length prefix allows")
+
+	timeType = reflect.TypeOf(time.Time{})
 )
 
 type Decoder struct {
-	msgTypes map[MsgType]reflect.Type
+	byCode map[MsgType]*registration
+	byType map[reflect.Type]MsgType
+	// direction of messages read, AnyDirection when not restricted
+	inbound Direction
 }
 
 func NewDecoder() *Decoder {
-	return &Decoder{msgTypes: make(map[MsgType]reflect.Type)}
+	return &Decoder{
+		byCode: make(map[MsgType]*registration),
+		byType: make(map[reflect.Type]MsgType),
+	}
 }
 
 func (d *Decoder) Unmarshall(payload []byte) (interface{}, int, error) {
-	dataSize := len(payload)
-	if dataSize == 0 {
+	if len(payload) == 0 {
 		return nil, 0, fmt.Errorf("empty payload")
 	}
 
-	msgType := MsgType(payload[0])
-	var (
-		t  reflect.Type
-		ok bool
-	)
-	if t, ok = d.msgTypes[msgType]; !ok {
-		return nil, 0, fmt.Errorf("message type (%d) not registered", msgType)
+	reg, err := d.lookupCode(MsgType(payload[0]), d.inbound)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	msg := reflect.Indirect(reflect.New(t))
-	offset := 1
-
-	if t.Kind() == reflect.Struct {
-		for i := 0; i < msg.NumField(); i++ {
-			switch msg.Field(i).Kind() {
-			case reflect.Uint8:
-				msg.Field(i).SetUint(uint64(payload[offset]))
-				offset++
-			case reflect.Uint16:
-				var v uint16
-				fromBigEndian(&v, payload[offset:offset+2])
-				msg.Field(i).SetUint(uint64(v))
-				offset += 2
-			case reflect.Uint32:
-				var v uint32
-				fromBigEndian(&v, payload[offset:offset+4])
-				msg.Field(i).SetUint(uint64(v))
-				offset += 4
-			case reflect.String:
-				strLen := uint8(payload[offset])
-				offset++
-				bytesCnt := int(strLen)
-				sliceSize := offset + bytesCnt
-				if sliceSize > dataSize {
-					return nil, 0, ErrIncompletePayload
-				}
-				msg.Field(i).SetString(string(payload[offset:sliceSize]))
-				offset += bytesCnt
-			case reflect.Struct:
-				switch msg.Field(i).Type() {
-				case reflect.TypeOf(time.Time{}):
-					var v uint32
-					fromBigEndian(&v, payload[offset:offset+4])
-					msg.Field(i).Set(reflect.ValueOf(time.Unix(int64(v), 0)))
-					offset += 4
-				}
-			case reflect.Slice:
-				switch msg.Field(i).Type() {
-				case reflect.TypeOf([]uint16{}):
-					arrLen := uint8(payload[offset])
-					offset++
-					var v = make([]uint16, arrLen)
-					bytesCnt := int(arrLen * 2)
-					sliceSize := offset + bytesCnt
-					if sliceSize > dataSize {
-						return nil, 0, ErrIncompletePayload
-					}
-					fromBigEndian(v, payload[offset:sliceSize])
-					msg.Field(i).Set(reflect.ValueOf(v))
-					offset += bytesCnt
-				}
-			}
+	if reg.Codec != nil {
+		msg, n, err := reg.Codec.Decode(payload[1:])
+		if err != nil {
+			return nil, 0, err
 		}
+		return msg, n + 1, nil
+	}
+
+	msg := reflect.Indirect(reflect.New(reg.Type))
+	r := NewWireReader(payload[1:])
+	reg.schema.decode(&r, msg)
+	if r.Err() != nil {
+		return nil, 0, r.Err()
 	}
 
-	return msg.Interface(), offset, nil
+	return msg.Interface(), r.Offset() + 1, nil
 }
 
 func (d *Decoder) Marshal(val interface{}) ([]byte, error) {
-	currentType := reflect.TypeOf(val)
-
-	var msgType MsgType
-	var mt reflect.Type
-	for msgType, mt = range d.msgTypes {
-		if currentType == mt {
-			break
-		}
+	reg, err := d.lookupType(reflect.TypeOf(val), d.inbound.outbound())
+	if err != nil {
+		return nil, err
 	}
 
-	if msgType == 0 {
-		return nil, fmt.Errorf("message type not registered")
+	payload := make([]byte, 1, 64)
+	payload[0] = byte(reg.MsgType)
+	if reg.Codec != nil {
+		payload, err = reg.Codec.Append(payload, val)
+	} else {
+		payload, err = reg.schema.encode(payload, reflect.ValueOf(val))
 	}
-
-	payload := make([]byte, 0)
-	payload = append(payload, byte(msgType))
-
-	v := reflect.ValueOf(val)
-
-	if v.Kind() == reflect.Struct {
-		for i := 0; i < v.NumField(); i++ {
-			switch v.Field(i).Kind() {
-			case reflect.Uint8:
-				payload = append(payload, byte(v.Field(i).Uint()))
-			case reflect.Uint16:
-				payload = append(payload, toBigEndian(uint16(v.Field(i).Uint()))...)
-			case reflect.Uint32:
-				payload = append(payload, toBigEndian(uint32(v.Field(i).Uint()))...)
-			case reflect.String:
-				val := v.Field(i).String()
-				strLen := uint8(len(val))
-				payload = append(payload, byte(strLen))
-				payload = append(payload, toBigEndian([]byte(val))...)
-			case reflect.Struct:
-				switch v.Field(i).Type() {
-				case reflect.TypeOf(time.Time{}):
-					var val uint32 = uint32(v.Field(i).Interface().(time.Time).Unix())
-					payload = append(payload, toBigEndian(val)...)
-				}
-			case reflect.Slice:
-				switch v.Field(i).Type() {
-				case reflect.TypeOf([]uint16{}):
-					val := v.Field(i).Interface().([]uint16)
-					arrLen := uint8(len(val))
-					payload = append(payload, byte(arrLen))
-					payload = append(payload, toBigEndian(val)...)
-				}
-			}
-
-		}
-
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", reflect.TypeOf(val).Name(), err)
 	}
-
 	return payload, nil
 }
-
-func (d *Decoder) RegisterMsg(m MsgType, t reflect.Type) {
-	d.msgTypes[m] = t
-}
-
-func fromBigEndian(v interface{}, b []byte) {
-	buf := bytes.NewReader(b)
-	binary.Read(buf, binary.BigEndian, v)
-}
-
-func toBigEndian(v interface{}) (b []byte) {
-	buf := bytes.NewBuffer(make([]byte, 0)) //?
-	binary.Write(buf, binary.BigEndian, v)
-	return buf.Bytes()
-}
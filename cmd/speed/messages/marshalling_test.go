@@ -3,6 +3,7 @@ package messages_test
 import (
 	"max-mulawa/echo/cmd/speed/messages"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -169,3 +170,85 @@ func TestMarshallingIncompletePayload(t *testing.T) {
 	require.Equal(t, messages.ErrIncompletePayload, err)
 	require.Equal(t, 0, cnt)
 }
+
+func TestUnmarshallingTruncatedFields(t *testing.T) {
+	type fieldsTest struct {
+		Kind      uint8
+		Road      uint16
+		Interval  uint32
+		Timestamp time.Time
+		Plate     string
+		Roads     []uint16
+	}
+
+	decoder := messages.NewDecoder()
+	decoder.RegisterMsg(messages.MsgType(16), reflect.TypeOf(fieldsTest{}))
+
+	payload := []byte{
+		0x10,       // message type
+		0x01,       // kind
+		0x00, 0x42, // road
+		0x00, 0x00, 0x00, 0x0a, // interval
+		0x00, 0x0f, 0x42, 0x40, // timestamp
+		0x02, 0x41, 0x42, // plate "AB"
+		0x01, 0x00, 0x42, // roads [66]
+	}
+	_, cnt, err := decoder.Unmarshall(payload)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), cnt)
+
+	for size := 1; size < len(payload); size++ {
+		v, cnt, err := decoder.Unmarshall(payload[:size])
+		require.Equal(t, messages.ErrIncompletePayload, err, "payload of %d bytes", size)
+		require.Nil(t, v)
+		require.Equal(t, 0, cnt)
+	}
+}
+
+func TestUnmarshallingLongArray(t *testing.T) {
+	type roadsTest struct {
+		Roads []uint16
+	}
+
+	decoder := messages.NewDecoder()
+	decoder.RegisterMsg(messages.MsgType(16), reflect.TypeOf(roadsTest{}))
+
+	roads := make([]uint16, 200)
+	for i := range roads {
+		roads[i] = uint16(i * 300)
+	}
+	payload, err := decoder.Marshal(roadsTest{Roads: roads})
+	require.NoError(t, err)
+	require.Len(t, payload, 2+400)
+
+	v, cnt, err := decoder.Unmarshall(payload)
+	require.NoError(t, err)
+	require.Equal(t, roads, v.(roadsTest).Roads)
+	require.Equal(t, len(payload), cnt)
+
+	_, _, err = decoder.Unmarshall(payload[:len(payload)-1])
+	require.Equal(t, messages.ErrIncompletePayload, err)
+}
+
+func TestMarshallingRejectsLongFields(t *testing.T) {
+	type longTest struct {
+		Msg   string
+		Roads []uint16
+	}
+
+	decoder := messages.NewDecoder()
+	decoder.RegisterMsg(messages.MsgType(16), reflect.TypeOf(longTest{}))
+
+	payload, err := decoder.Marshal(longTest{Msg: strings.Repeat("x", 255), Roads: make([]uint16, 255)})
+	require.NoError(t, err)
+	v, cnt, err := decoder.Unmarshall(payload)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), cnt)
+	require.Equal(t, strings.Repeat("x", 255), v.(longTest).Msg)
+	require.Len(t, v.(longTest).Roads, 255)
+
+	_, err = decoder.Marshal(longTest{Msg: strings.Repeat("x", 256)})
+	require.ErrorIs(t, err, messages.ErrValueTooLong)
+	_, err = decoder.Marshal(longTest{Roads: make([]uint16, 256)})
+	require.ErrorIs(t, err, messages.ErrValueTooLong)
+}
@@ -1,66 +1,190 @@
 package messages
 
 import (
-	"encoding/hex"
 	"fmt"
 	"io"
 )
 
+const (
+	DefaultBufferSize = 1024
+	// MaxMessageSize limits how far the buffer grows for a message larger than it
+	MaxMessageSize = 1 << 20
+)
+
 var (
-	ErrClientClosed = fmt.Errorf("client closed connection")
+	ErrClientClosed    = fmt.Errorf("client closed connection")
+	ErrMessageTooLarge = fmt.Errorf("message larger than %d bytes", MaxMessageSize)
 )
 
+// Message is a decoded message, a value of its registered type
+type Message interface{}
+
+// Reader decodes messages of a stream, bytes are read into a ring buffer reused for the whole stream
 type Reader struct {
 	conn    io.Reader
 	decoder *Decoder
+
+	buf []byte
+	// buffered bytes start at start and may wrap around the end of buf
+	start int
+	n     int
+	// called with the bytes of every decoded message, they are valid until it returns
+	onPayload func(payload []byte)
+	// error of a read which also returned bytes, reported once they are decoded
+	readErr error
+	err     error
 }
 
 func NewReader(conn io.Reader, decoder *Decoder) *Reader {
+	return NewReaderSize(conn, decoder, DefaultBufferSize)
+}
+
+// NewReaderSize returns a reader with a buffer of size bytes, it grows when a message does not fit
+func NewReaderSize(conn io.Reader, decoder *Decoder, size int) *Reader {
+	if size < 1 {
+		size = DefaultBufferSize
+	}
 	return &Reader{
 		conn:    conn,
 		decoder: decoder,
+		buf:     make([]byte, size),
+	}
+}
+
+// OnPayload sets a function called with the bytes of every decoded message before it is returned
+func (r *Reader) OnPayload(f func(payload []byte)) {
+	r.onPayload = f
+}
+
+// Next returns the next message, once it fails it keeps returning the same error. ErrClientClosed is returned
+// when the stream ends, bytes of an incomplete message at its end are dropped
+func (r *Reader) Next() (Message, error) {
+	for r.err == nil {
+		if r.n > 0 {
+			msg, err := r.decode()
+			if err != nil {
+				r.err = fmt.Errorf("failure during unmarshalling of message: %w", err)
+				break
+			}
+			if msg != nil {
+				return msg, nil
+			}
+		}
+		r.err = r.fill()
 	}
+	return nil, r.err
 }
 
+// GetMessages sends messages returned by Next followed by the error stopping it, then the channel is closed
 func (r *Reader) GetMessages() <-chan interface{} {
 	messages := make(chan interface{})
 	go func() {
 		defer close(messages)
-		bufSize := 1024
-		incomplete := []byte{}
-
 		for {
-			buffer := make([]byte, bufSize)
-			start := 0
-			readCnt, err := r.conn.Read(buffer)
+			msg, err := r.Next()
 			if err != nil {
-				if err != io.EOF {
-					messages <- fmt.Errorf("read error: %w", err)
-				} else {
-					messages <- ErrClientClosed
-				}
-				break
-			}
-
-			payloadBuf := append(incomplete, buffer[start:readCnt]...)
-			payloadBufCnt := len(payloadBuf)
-			incomplete = []byte{}
-
-			for start < payloadBufCnt {
-				payload := payloadBuf[start:]
-				msg, cntBytes, err := r.decoder.Unmarshall(payload)
-				if err == ErrIncompletePayload {
-					incomplete = payload
-					break
-				} else if err != nil {
-					messages <- fmt.Errorf("failure during unmarshalling of message: %w", err)
-					return
-				}
-				fmt.Printf("message size: %d, payload: %+v\n", cntBytes, hex.EncodeToString(payload[:cntBytes]))
-				start += cntBytes
-				messages <- msg
+				messages <- err
+				return
 			}
+			messages <- msg
 		}
 	}()
 	return messages
 }
+
+// decode unmarshalls the first buffered message, it returns nil when more bytes are needed
+func (r *Reader) decode() (Message, error) {
+	head := r.buf[r.start:minInt(r.start+r.n, len(r.buf))]
+	msg, size, err := r.decoder.Unmarshall(head)
+	if err == ErrIncompletePayload && len(head) < r.n {
+		// the message wraps around the end of the buffer
+		r.rotate()
+		head = r.buf[:r.n]
+		msg, size, err = r.decoder.Unmarshall(head)
+	}
+	if err == ErrIncompletePayload {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.onPayload != nil {
+		r.onPayload(head[:size])
+	}
+	r.n -= size
+	r.start = (r.start + size) % len(r.buf)
+	if r.n == 0 {
+		// the next read fills the whole buffer
+		r.start = 0
+	}
+	return msg, nil
+}
+
+// fill reads into the free space following the buffered bytes
+func (r *Reader) fill() error {
+	if r.readErr != nil {
+		return r.readErr
+	}
+	if r.n == len(r.buf) {
+		err := r.grow()
+		if err != nil {
+			return err
+		}
+	}
+
+	end := (r.start + r.n) % len(r.buf)
+	limit := len(r.buf)
+	if end < r.start {
+		limit = r.start
+	}
+	cnt, err := r.conn.Read(r.buf[end:limit])
+	r.n += cnt
+
+	if err == io.EOF {
+		err = ErrClientClosed
+	} else if err != nil {
+		err = fmt.Errorf("read error: %w", err)
+	}
+	if cnt > 0 {
+		r.readErr = err
+		return nil
+	}
+	return err
+}
+
+// grow doubles the full buffer of a message larger than it
+func (r *Reader) grow() error {
+	if len(r.buf) >= MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	r.rotate()
+	buf := make([]byte, minInt(2*len(r.buf), MaxMessageSize))
+	copy(buf, r.buf[:r.n])
+	r.buf = buf
+	return nil
+}
+
+// rotate moves the buffered bytes to the beginning of the buffer in place
+func (r *Reader) rotate() {
+	if r.start == 0 {
+		return
+	}
+	reverse(r.buf[:r.start])
+	reverse(r.buf[r.start:])
+	reverse(r.buf)
+	r.start = 0
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -3,9 +3,14 @@ package messages_test
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/ops"
 	"reflect"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
 )
@@ -40,3 +45,161 @@ func TestGetMessages(t *testing.T) {
 
 	require.Equal(t, 3, msgCounter)
 }
+
+func TestReaderNext(t *testing.T) {
+	generated, _ := fuzzDecoders(t)
+	seeds := seedPayloads(t)
+	var stream []byte
+	for i := 0; i < 10; i++ {
+		for _, seed := range seeds {
+			stream = append(stream, seed...)
+		}
+	}
+	expected, err := decodeAll(generated, stream)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		size int
+		conn io.Reader
+	}{
+		{"default buffer", messages.DefaultBufferSize, bytes.NewReader(stream)},
+		{"one byte reads", messages.DefaultBufferSize, iotest.OneByteReader(bytes.NewReader(stream))},
+		{"messages wrapping around the buffer", 32, iotest.HalfReader(bytes.NewReader(stream))},
+		{"messages larger than the buffer", 4, bytes.NewReader(stream)},
+		{"eof with data", 16, iotest.DataErrReader(bytes.NewReader(stream))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := messages.NewReaderSize(tt.conn, generated, tt.size)
+			var got []interface{}
+			for {
+				msg, err := r.Next()
+				if err != nil {
+					require.Equal(t, messages.ErrClientClosed, err)
+					break
+				}
+				got = append(got, msg)
+			}
+			require.Equal(t, expected, got)
+
+			_, err := r.Next()
+			require.Equal(t, messages.ErrClientClosed, err)
+		})
+	}
+}
+
+func TestReaderOnPayload(t *testing.T) {
+	generated, _ := fuzzDecoders(t)
+	seeds := seedPayloads(t)
+	var stream []byte
+	for _, seed := range seeds {
+		stream = append(stream, seed...)
+	}
+
+	// small buffer so payloads wrap around and grow it
+	r := messages.NewReaderSize(iotest.HalfReader(bytes.NewReader(stream)), generated, 4)
+	var payloads []byte
+	r.OnPayload(func(payload []byte) {
+		payloads = append(payloads, payload...)
+	})
+	for {
+		_, err := r.Next()
+		if err != nil {
+			require.Equal(t, messages.ErrClientClosed, err)
+			break
+		}
+	}
+	require.Equal(t, stream, payloads)
+}
+
+func TestReaderErrors(t *testing.T) {
+	type largeMsg struct {
+		A []uint64 `wire:"array16"`
+		B []uint64 `wire:"array16"`
+		C []uint64 `wire:"array16"`
+	}
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterMsg(messages.MsgType(16), reflect.TypeOf(largeMsg{})))
+
+	tests := []struct {
+		name string
+		conn io.Reader
+		err  string
+	}{
+		{"unknown message", bytes.NewReader([]byte{0x99}), "failure during unmarshalling of message"},
+		{"read error", iotest.ErrReader(errors.New("reset")), "read error: reset"},
+		{"message too large", io.MultiReader(bytes.NewReader([]byte{0x10}), repeatReader(0xff)), messages.ErrMessageTooLarge.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := messages.NewReader(tt.conn, decoder)
+			_, err := r.Next()
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestReaderDoesNotAllocate(t *testing.T) {
+	// heartbeats are empty structs, decoding them allocates nothing either
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterCodec(ops.HeartbeatMsgType, ops.HearbeatSignalCodec))
+	require.NoError(t, decoder.RegisterCodec(ops.HeartbeatRequestMsgType, ops.HeartbeatRequestCodec))
+	stream := []byte{0x41, 0x40, 0x00, 0x00, 0x00, 0x19, 0x41}
+
+	r := messages.NewReaderSize(&loopReader{data: stream, size: 3}, decoder, 4)
+	allocs := testing.AllocsPerRun(1000, func() {
+		_, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	require.Zero(t, allocs)
+}
+
+// repeatReader returns its byte endlessly
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+// loopReader returns data over and over in chunks of size bytes
+type loopReader struct {
+	data   []byte
+	size   int
+	offset int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := minInt(minInt(len(p), r.size), len(r.data)-r.offset)
+	copy(p, r.data[r.offset:r.offset+n])
+	r.offset = (r.offset + n) % len(r.data)
+	return n, nil
+}
+
+func BenchmarkReader(b *testing.B) {
+	generated, _ := fuzzDecoders(b)
+	var stream []byte
+	for _, seed := range seedPayloads(b) {
+		stream = append(stream, seed...)
+	}
+
+	for _, chunk := range []int{1, 3, 17, 4096} {
+		b.Run(fmt.Sprintf("next/chunks of %d", chunk), func(b *testing.B) {
+			r := messages.NewReaderSize(&loopReader{data: stream, size: chunk}, generated, 64)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := r.Next()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+var (
+	ErrNotRegistered     = errors.New("not registered")
+	ErrAlreadyRegistered = errors.New("already registered")
+	ErrWrongDirection    = errors.New("not allowed in this direction")
+)
+
+// Direction a message is sent in
+type Direction int
+
+const (
+	AnyDirection Direction = iota
+	ClientToServer
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	switch d {
+	case AnyDirection:
+		return "any"
+	case ClientToServer:
+		return "client->server"
+	case ServerToClient:
+		return "server->client"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// Registration binds a message type code to a Go type, Type may be left out when Codec is set
+type Registration struct {
+	MsgType   MsgType
+	Type      reflect.Type
+	Direction Direction
+	// nil for types encoded by reflection
+	Codec *Codec
+}
+
+func (r Registration) String() string {
+	encoding := "reflection"
+	if r.Codec != nil {
+		encoding = "codec"
+	}
+	return fmt.Sprintf("0x%02x %s %s (%s)", byte(r.MsgType), r.Type, r.Direction, encoding)
+}
+
+type registration struct {
+	Registration
+	schema *wireType
+}
+
+// Register adds a message type, codes and Go types are bound one to one so registering either twice fails
+func (d *Decoder) Register(r Registration) error {
+	if r.Codec != nil {
+		if r.Type != nil && r.Type != r.Codec.Type {
+			return fmt.Errorf("message type (%d): codec of %s registered for %s", r.MsgType, r.Codec.Type, r.Type)
+		}
+		r.Type = r.Codec.Type
+	}
+	if r.Type == nil {
+		return fmt.Errorf("message type (%d) has no type", r.MsgType)
+	}
+
+	if existing, ok := d.byCode[r.MsgType]; ok {
+		return fmt.Errorf("message type (%d) %w as %s", r.MsgType, ErrAlreadyRegistered, existing.Type)
+	}
+	if code, ok := d.byType[r.Type]; ok {
+		return fmt.Errorf("%s %w as message type (%d)", r.Type, ErrAlreadyRegistered, code)
+	}
+
+	schema, err := newSchema(r.Type)
+	if err != nil {
+		return fmt.Errorf("message type (%d) %s: %w", r.MsgType, r.Type, err)
+	}
+
+	d.byCode[r.MsgType] = &registration{Registration: r, schema: schema}
+	d.byType[r.Type] = r.MsgType
+	return nil
+}
+
+// RegisterMsg registers the message type decoded and encoded by walking its fields with reflection,
+// `wire` tags of the fields set their encoding
+func (d *Decoder) RegisterMsg(m MsgType, t reflect.Type) error {
+	return d.Register(Registration{MsgType: m, Type: t})
+}
+
+// RegisterCodec registers the message type with its generated codec
+func (d *Decoder) RegisterCodec(m MsgType, c Codec) error {
+	return d.Register(Registration{MsgType: m, Codec: &c})
+}
+
+// Registrations lists registered message types ordered by their codes
+func (d *Decoder) Registrations() []Registration {
+	regs := make([]Registration, 0, len(d.byCode))
+	for _, r := range d.byCode {
+		regs = append(regs, r.Registration)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].MsgType < regs[j].MsgType })
+	return regs
+}
+
+// Inbound restricts Unmarshall to messages sent in the direction and Marshal to messages sent in the opposite one,
+// a server reads client to server messages and writes server to client ones
+func (d *Decoder) Inbound(dir Direction) {
+	d.inbound = dir
+}
+
+func (d *Decoder) lookupCode(m MsgType, dir Direction) (*registration, error) {
+	r, ok := d.byCode[m]
+	if !ok {
+		return nil, fmt.Errorf("message type (%d) %w", m, ErrNotRegistered)
+	}
+	if !r.allowed(dir) {
+		return nil, fmt.Errorf("message type (%d) sent %s %w", m, r.Direction, ErrWrongDirection)
+	}
+	return r, nil
+}
+
+func (d *Decoder) lookupType(t reflect.Type, dir Direction) (*registration, error) {
+	code, ok := d.byType[t]
+	if !ok {
+		return nil, fmt.Errorf("message type of %s %w", t, ErrNotRegistered)
+	}
+	return d.lookupCode(code, dir)
+}
+
+func (r *registration) allowed(dir Direction) bool {
+	return dir == AnyDirection || r.Direction == AnyDirection || r.Direction == dir
+}
+
+// outbound is the direction of messages written by the side reading inbound ones
+func (d Direction) outbound() Direction {
+	switch d {
+	case ClientToServer:
+		return ServerToClient
+	case ServerToClient:
+		return ClientToServer
+	default:
+		return AnyDirection
+	}
+}
@@ -0,0 +1,95 @@
+package messages_test
+
+import (
+	"errors"
+	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/ops"
+	"max-mulawa/echo/cmd/speed/tracking"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterConflicts(t *testing.T) {
+	type otherMsg struct {
+		Msg string
+	}
+
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterCodec(ops.ErrorMsgType, ops.ServerErrorCodec))
+
+	err := decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(otherMsg{}))
+	require.True(t, errors.Is(err, messages.ErrAlreadyRegistered), err)
+	err = decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{}))
+	require.True(t, errors.Is(err, messages.ErrAlreadyRegistered), err)
+	err = decoder.RegisterMsg(messages.MsgType(17), reflect.TypeOf(ops.ServerError{}))
+	require.True(t, errors.Is(err, messages.ErrAlreadyRegistered), err)
+	err = decoder.Register(messages.Registration{MsgType: 18, Type: reflect.TypeOf(otherMsg{}), Codec: &ops.ServerErrorCodec})
+	require.ErrorContains(t, err, "codec of ops.ServerError registered for messages_test.otherMsg")
+
+	// the failed registrations left the registry untouched
+	payload, err := decoder.Marshal(ops.ServerError{Msg: "bad"})
+	require.NoError(t, err)
+	msg, _, err := decoder.Unmarshall(payload)
+	require.NoError(t, err)
+	require.Equal(t, ops.ServerError{Msg: "bad"}, msg)
+	_, err = decoder.Marshal(otherMsg{})
+	require.True(t, errors.Is(err, messages.ErrNotRegistered), err)
+}
+
+func TestMessageTypeZero(t *testing.T) {
+	type zeroMsg struct {
+		Value uint8
+	}
+
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterMsg(messages.MsgType(0), reflect.TypeOf(zeroMsg{})))
+
+	payload, err := decoder.Marshal(zeroMsg{Value: 7})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x07}, payload)
+	msg, _, err := decoder.Unmarshall(payload)
+	require.NoError(t, err)
+	require.Equal(t, zeroMsg{Value: 7}, msg)
+}
+
+func TestDirections(t *testing.T) {
+	server := messages.NewDecoder()
+	require.NoError(t, server.Register(messages.Registration{MsgType: tracking.IAmCameraMsgType, Direction: messages.ClientToServer, Codec: &tracking.IAmCameraMsgCodec}))
+	require.NoError(t, server.Register(messages.Registration{MsgType: ops.ErrorMsgType, Direction: messages.ServerToClient, Codec: &ops.ServerErrorCodec}))
+	server.Inbound(messages.ClientToServer)
+
+	client := messages.NewDecoder()
+	require.NoError(t, client.RegisterCodec(tracking.IAmCameraMsgType, tracking.IAmCameraMsgCodec))
+	require.NoError(t, client.RegisterCodec(ops.ErrorMsgType, ops.ServerErrorCodec))
+
+	camera, err := client.Marshal(tracking.IAmCameraMsg{Road: 1})
+	require.NoError(t, err)
+	_, _, err = server.Unmarshall(camera)
+	require.NoError(t, err)
+	_, err = server.Marshal(tracking.IAmCameraMsg{Road: 1})
+	require.True(t, errors.Is(err, messages.ErrWrongDirection), err)
+
+	serverErr, err := client.Marshal(ops.ServerError{Msg: "bad"})
+	require.NoError(t, err)
+	_, _, err = server.Unmarshall(serverErr)
+	require.True(t, errors.Is(err, messages.ErrWrongDirection), err)
+	_, err = server.Marshal(ops.ServerError{Msg: "bad"})
+	require.NoError(t, err)
+}
+
+func TestRegistrations(t *testing.T) {
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.Register(messages.Registration{MsgType: tracking.IAmCameraMsgType, Direction: messages.ClientToServer, Codec: &tracking.IAmCameraMsgCodec}))
+	require.NoError(t, decoder.RegisterMsg(ops.ErrorMsgType, reflect.TypeOf(ops.ServerError{})))
+
+	var listed []string
+	for _, r := range decoder.Registrations() {
+		listed = append(listed, r.String())
+	}
+	require.Equal(t, []string{
+		"0x10 ops.ServerError any (reflection)",
+		"0x80 tracking.IAmCameraMsg client->server (codec)",
+	}, listed)
+}
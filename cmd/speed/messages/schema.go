@@ -0,0 +1,327 @@
+package messages
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// wireTag sets the encoding of a field, e.g. `wire:"u32"`, `wire:"str8"` or `wire:"array8,elem=u16"`. Encodings are
+//
+//	u8 u16 u32 u64      unsigned big endian integers
+//	i8 i16 i32 i64      signed big endian integers
+//	str8 str16          strings prefixed with their u8 or u16 length
+//	time32 time64       time.Time as u32 or u64 seconds since the epoch
+//	array8 array16      slices prefixed with their u8 or u16 count, elem sets the encoding of values
+//	bytes               fixed size byte arrays
+//	struct              nested structs encoded field by field
+//	-                   fields left out of the message
+//
+// fields without a tag are encoded by their type, strings as str8, time.Time as time32 and slices as array8
+const wireTag = "wire"
+
+type wireKind int
+
+const (
+	wireUint wireKind = iota
+	wireInt
+	wireString
+	wireTime
+	wireArray
+	wireBytes
+	wireStruct
+)
+
+// wireType describes how a value is encoded, size is the width of integers, times and length prefixes
+type wireType struct {
+	kind   wireKind
+	size   int
+	elem   *wireType
+	fields []wireField
+}
+
+type wireField struct {
+	index int
+	enc   *wireType
+}
+
+var encodings = map[string]struct {
+	kind wireKind
+	size int
+}{
+	"u8":      {wireUint, 1},
+	"u16":     {wireUint, 2},
+	"u32":     {wireUint, 4},
+	"u64":     {wireUint, 8},
+	"i8":      {wireInt, 1},
+	"i16":     {wireInt, 2},
+	"i32":     {wireInt, 4},
+	"i64":     {wireInt, 8},
+	"str8":    {wireString, 1},
+	"str16":   {wireString, 2},
+	"time32":  {wireTime, 4},
+	"time64":  {wireTime, 8},
+	"array8":  {wireArray, 1},
+	"array16": {wireArray, 2},
+	"bytes":   {wireBytes, 0},
+	"struct":  {wireStruct, 0},
+}
+
+// newSchema reads the encoding of a message type from its fields and their tags
+func newSchema(t reflect.Type) (*wireType, error) {
+	return encodingOf(t, "", map[reflect.Type]bool{})
+}
+
+// encodingOf reads the encoding of a value, visiting holds structs being read so a struct containing
+// itself fails instead of recursing forever
+func encodingOf(t reflect.Type, tag string, visiting map[reflect.Type]bool) (*wireType, error) {
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = defaultEncoding(t)
+		if name == "" {
+			return nil, fmt.Errorf("type %s has no wire encoding", t)
+		}
+	}
+
+	e, ok := encodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown wire encoding %q", name)
+	}
+	enc := &wireType{kind: e.kind, size: e.size}
+
+	switch e.kind {
+	case wireUint:
+		if !isUint(t.Kind()) || int(t.Size()) < e.size {
+			return nil, fmt.Errorf("%s does not fit %s", name, t)
+		}
+	case wireInt:
+		if !isInt(t.Kind()) || int(t.Size()) < e.size {
+			return nil, fmt.Errorf("%s does not fit %s", name, t)
+		}
+	case wireString:
+		if t.Kind() != reflect.String {
+			return nil, fmt.Errorf("%s needs a string, not %s", name, t)
+		}
+	case wireTime:
+		if t != timeType {
+			return nil, fmt.Errorf("%s needs a time.Time, not %s", name, t)
+		}
+	case wireBytes:
+		if t.Kind() != reflect.Array || t.Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("bytes needs a byte array, not %s", t)
+		}
+		enc.size = t.Len()
+	case wireArray:
+		if t.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("%s needs a slice, not %s", name, t)
+		}
+		elemTag := ""
+		if opts != "" {
+			opt, v, _ := strings.Cut(opts, "=")
+			if opt != "elem" {
+				return nil, fmt.Errorf("unknown option %q of %s", opts, name)
+			}
+			elemTag = v
+		}
+		elem, err := encodingOf(t.Elem(), elemTag, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("elements of %s: %w", t, err)
+		}
+		enc.elem = elem
+	case wireStruct:
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("struct needs a struct, not %s", t)
+		}
+		if visiting[t] {
+			return nil, fmt.Errorf("%s contains itself", t)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get(wireTag)
+			if tag == "-" || !f.IsExported() {
+				continue
+			}
+			fieldEnc, err := encodingOf(f.Type, tag, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name, err)
+			}
+			enc.fields = append(enc.fields, wireField{index: i, enc: fieldEnc})
+		}
+	}
+	if opts != "" && e.kind != wireArray {
+		return nil, fmt.Errorf("%s takes no options", name)
+	}
+	return enc, nil
+}
+
+func defaultEncoding(t reflect.Type) string {
+	if t == timeType {
+		return "time32"
+	}
+	switch t.Kind() {
+	case reflect.Uint8:
+		return "u8"
+	case reflect.Uint16:
+		return "u16"
+	case reflect.Uint32:
+		return "u32"
+	case reflect.Uint64:
+		return "u64"
+	case reflect.Int8:
+		return "i8"
+	case reflect.Int16:
+		return "i16"
+	case reflect.Int32:
+		return "i32"
+	case reflect.Int64:
+		return "i64"
+	case reflect.String:
+		return "str8"
+	case reflect.Slice:
+		return "array8"
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+	case reflect.Struct:
+		return "struct"
+	}
+	return ""
+}
+
+func isUint(k reflect.Kind) bool {
+	return k == reflect.Uint8 || k == reflect.Uint16 || k == reflect.Uint32 || k == reflect.Uint64 || k == reflect.Uint
+}
+
+func isInt(k reflect.Kind) bool {
+	return k == reflect.Int8 || k == reflect.Int16 || k == reflect.Int32 || k == reflect.Int64 || k == reflect.Int
+}
+
+func (w *wireType) decode(r *WireReader, v reflect.Value) {
+	switch w.kind {
+	case wireUint:
+		v.SetUint(r.uint(w.size))
+	case wireInt:
+		v.SetInt(signExtend(r.uint(w.size), w.size))
+	case wireString:
+		v.SetString(string(r.next(int(r.uint(w.size)))))
+	case wireTime:
+		secs := r.uint(w.size)
+		if r.err == nil {
+			v.Set(reflect.ValueOf(time.Unix(int64(secs), 0)))
+		}
+	case wireBytes:
+		reflect.Copy(v, reflect.ValueOf(r.next(w.size)))
+	case wireArray:
+		n := int(r.uint(w.size))
+		if r.err != nil {
+			return
+		}
+		// every element takes at least a byte, bogus counts fail before allocating
+		if n > len(r.payload)-r.offset && w.elem.minSize() > 0 {
+			r.err = ErrIncompletePayload
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			w.elem.decode(r, s.Index(i))
+		}
+		v.Set(s)
+	case wireStruct:
+		for _, f := range w.fields {
+			f.enc.decode(r, v.Field(f.index))
+		}
+	}
+}
+
+func (w *wireType) encode(b []byte, v reflect.Value) ([]byte, error) {
+	switch w.kind {
+	case wireUint:
+		return appendUint(b, v.Uint(), w.size), nil
+	case wireInt:
+		return appendUint(b, uint64(v.Int()), w.size), nil
+	case wireString:
+		s := v.String()
+		if len(s) > maxLength(w.size) {
+			return nil, fmt.Errorf("string of %d bytes: %w", len(s), ErrValueTooLong)
+		}
+		b = appendUint(b, uint64(len(s)), w.size)
+		return append(b, s...), nil
+	case wireTime:
+		return appendUint(b, uint64(v.Interface().(time.Time).Unix()), w.size), nil
+	case wireBytes:
+		for i := 0; i < v.Len(); i++ {
+			b = append(b, byte(v.Index(i).Uint()))
+		}
+		return b, nil
+	case wireArray:
+		n := v.Len()
+		if n > maxLength(w.size) {
+			return nil, fmt.Errorf("array of %d values: %w", n, ErrValueTooLong)
+		}
+		b = appendUint(b, uint64(n), w.size)
+		for i := 0; i < n; i++ {
+			var err error
+			b, err = w.elem.encode(b, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case wireStruct:
+		for _, f := range w.fields {
+			var err error
+			b, err = f.enc.encode(b, v.Field(f.index))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// minSize is the least number of bytes a value takes
+func (w *wireType) minSize() int {
+	switch w.kind {
+	case wireStruct:
+		n := 0
+		for _, f := range w.fields {
+			n += f.enc.minSize()
+		}
+		return n
+	default:
+		return w.size
+	}
+}
+
+func (r *WireReader) uint(size int) uint64 {
+	b := r.next(size)
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func appendUint(b []byte, v uint64, size int) []byte {
+	for shift := (size - 1) * 8; shift >= 0; shift -= 8 {
+		b = append(b, byte(v>>shift))
+	}
+	return b
+}
+
+func signExtend(v uint64, size int) int64 {
+	shift := 64 - size*8
+	return int64(v<<shift) >> shift
+}
+
+func maxLength(prefixSize int) int {
+	if prefixSize == 1 {
+		return math.MaxUint8
+	}
+	return math.MaxUint16
+}
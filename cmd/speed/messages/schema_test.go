@@ -0,0 +1,130 @@
+package messages_test
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type point struct {
+	X int16 `wire:"i16"`
+	Y int16 `wire:"i16"`
+}
+
+type schemaTest struct {
+	Signed   int32     `wire:"i32"`
+	Big      uint64    `wire:"u64"`
+	Small    uint32    `wire:"u16"`
+	Name     string    `wire:"str16"`
+	At       time.Time `wire:"time64"`
+	Origin   point
+	Path     []point  `wire:"array8"`
+	Ids      []uint32 `wire:"array16,elem=u16"`
+	Tags     []string `wire:"array8,elem=str8"`
+	Hash     [4]byte  `wire:"bytes"`
+	Internal string   `wire:"-"`
+}
+
+func TestSchema(t *testing.T) {
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterMsg(messages.MsgType(16), reflect.TypeOf(schemaTest{})))
+
+	msg := schemaTest{
+		Signed: -2,
+		Big:    1 << 40,
+		Small:  513,
+		Name:   "ab",
+		At:     time.Unix(1<<33, 0),
+		Origin: point{X: -1, Y: 1},
+		Path:   []point{{X: 2, Y: -2}},
+		Ids:    []uint32{7},
+		Tags:   []string{"x", ""},
+		Hash:   [4]byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	payload := []byte{
+		0x10,                   // message type
+		0xff, 0xff, 0xff, 0xfe, // signed i32(-2)
+		0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, // big u64(1<<40)
+		0x02, 0x01, // small u16(513)
+		0x00, 0x02, 0x61, 0x62, // name str16 "ab"
+		0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, // at time64
+		0xff, 0xff, 0x00, 0x01, // origin {-1, 1}
+		0x01, 0x00, 0x02, 0xff, 0xfe, // path [{2, -2}]
+		0x00, 0x01, 0x00, 0x07, // ids array16 of u16 [7]
+		0x02, 0x01, 0x78, 0x00, // tags ["x", ""]
+		0xde, 0xad, 0xbe, 0xef, // hash
+	}
+
+	encoded, err := decoder.Marshal(msg)
+	require.NoError(t, err)
+	require.Equal(t, payload, encoded)
+
+	v, cnt, err := decoder.Unmarshall(payload)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), cnt)
+	require.Equal(t, msg, v)
+
+	for size := 1; size < len(payload); size++ {
+		_, _, err := decoder.Unmarshall(payload[:size])
+		require.Equal(t, messages.ErrIncompletePayload, err, "payload of %d bytes", size)
+	}
+}
+
+// other protohackers protocols are described the same way, e.g. inserts of Means to an End
+func TestSchemaMeansToAnEnd(t *testing.T) {
+	type insertMsg struct {
+		Timestamp int32
+		Price     int32
+	}
+
+	decoder := messages.NewDecoder()
+	require.NoError(t, decoder.RegisterMsg(messages.MsgType('I'), reflect.TypeOf(insertMsg{})))
+
+	v, cnt, err := decoder.Unmarshall([]byte{0x49, 0x00, 0x00, 0x30, 0x39, 0xff, 0xff, 0xff, 0x9c})
+	require.NoError(t, err)
+	require.Equal(t, 9, cnt)
+	require.Equal(t, insertMsg{Timestamp: 12345, Price: -100}, v)
+}
+
+type treeTest struct {
+	Children []treeTest
+}
+
+func TestSchemaErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		msg  interface{}
+		err  string
+	}{
+		{desc: "unknown encoding", msg: struct {
+			V uint32 `wire:"u24"`
+		}{}, err: `unknown wire encoding "u24"`},
+		{desc: "too narrow field", msg: struct {
+			V uint16 `wire:"u32"`
+		}{}, err: "u32 does not fit uint16"},
+		{desc: "signedness", msg: struct {
+			V uint32 `wire:"i32"`
+		}{}, err: "i32 does not fit uint32"},
+		{desc: "unsupported type", msg: struct {
+			V float64
+		}{}, err: "type float64 has no wire encoding"},
+		{desc: "element encoding", msg: struct {
+			V []uint16 `wire:"array8,elem=str8"`
+		}{}, err: "str8 needs a string"},
+		{desc: "unknown option", msg: struct {
+			V []uint16 `wire:"array8,size=2"`
+		}{}, err: `unknown option "size=2"`},
+		{desc: "option of scalar", msg: struct {
+			V uint16 `wire:"u16,elem=u8"`
+		}{}, err: "u16 takes no options"},
+		{desc: "struct containing itself", msg: treeTest{}, err: "messages_test.treeTest contains itself"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := messages.NewDecoder().RegisterMsg(messages.MsgType(16), reflect.TypeOf(tc.msg))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
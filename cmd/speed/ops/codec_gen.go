@@ -0,0 +1,99 @@
+// Code generated by codecgen; DO NOT EDIT.
+
+package ops
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"reflect"
+)
+
+// HeartbeatRequestCodec encodes and decodes HeartbeatRequest without reflection
+var HeartbeatRequestCodec = messages.Codec{
+	Type: reflect.TypeOf(HeartbeatRequest{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendHeartbeatRequest(b, msg.(HeartbeatRequest))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeHeartbeatRequest(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendHeartbeatRequest(b []byte, m HeartbeatRequest) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	w.Uint32(m.Interval)
+	return w.Bytes(), w.Err()
+}
+
+func decodeHeartbeatRequest(payload []byte) (HeartbeatRequest, int, error) {
+	r := messages.NewWireReader(payload)
+	var m HeartbeatRequest
+	m.Interval = r.Uint32()
+	if r.Err() != nil {
+		return HeartbeatRequest{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
+
+// HearbeatSignalCodec encodes and decodes HearbeatSignal without reflection
+var HearbeatSignalCodec = messages.Codec{
+	Type: reflect.TypeOf(HearbeatSignal{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendHearbeatSignal(b, msg.(HearbeatSignal))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeHearbeatSignal(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendHearbeatSignal(b []byte, m HearbeatSignal) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	return w.Bytes(), w.Err()
+}
+
+func decodeHearbeatSignal(payload []byte) (HearbeatSignal, int, error) {
+	r := messages.NewWireReader(payload)
+	var m HearbeatSignal
+	if r.Err() != nil {
+		return HearbeatSignal{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
+
+// ServerErrorCodec encodes and decodes ServerError without reflection
+var ServerErrorCodec = messages.Codec{
+	Type: reflect.TypeOf(ServerError{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendServerError(b, msg.(ServerError))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeServerError(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendServerError(b []byte, m ServerError) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	w.String8(m.Msg)
+	return w.Bytes(), w.Err()
+}
+
+func decodeServerError(payload []byte) (ServerError, int, error) {
+	r := messages.NewWireReader(payload)
+	var m ServerError
+	m.Msg = r.String8()
+	if r.Err() != nil {
+		return ServerError{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
@@ -1,5 +1,7 @@
 package ops
 
+//go:generate go run max-mulawa/echo/cmd/speed/messages/codecgen -types HeartbeatRequest,HearbeatSignal,ServerError
+
 import "max-mulawa/echo/cmd/speed/messages"
 
 var HeartbeatRequestMsgType messages.MsgType = 64 // 0x40
@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"fmt"
+	"max-mulawa/echo/cmd/speed/messages"
+	"max-mulawa/echo/cmd/speed/ops"
+	"max-mulawa/echo/cmd/speed/ticketing"
+	"max-mulawa/echo/cmd/speed/tracking"
+)
+
+// Messages of the speed protocol with directions they are sent in
+var Messages = []messages.Registration{
+	{MsgType: tracking.IAmCameraMsgType, Direction: messages.ClientToServer, Codec: &tracking.IAmCameraMsgCodec},
+	{MsgType: tracking.MeasurementTimeMsgType, Direction: messages.ClientToServer, Codec: &tracking.MeasurementTimeMsgCodec},
+
+	{MsgType: ticketing.IAmDispatcherMsgType, Direction: messages.ClientToServer, Codec: &ticketing.IAmDispatcherMsgCodec},
+	{MsgType: ticketing.TicketMsgType, Direction: messages.ServerToClient, Codec: &ticketing.TicketMsgCodec},
+
+	{MsgType: ops.HeartbeatRequestMsgType, Direction: messages.ClientToServer, Codec: &ops.HeartbeatRequestCodec},
+	{MsgType: ops.HeartbeatMsgType, Direction: messages.ServerToClient, Codec: &ops.HearbeatSignalCodec},
+
+	{MsgType: ops.ErrorMsgType, Direction: messages.ServerToClient, Codec: &ops.ServerErrorCodec},
+}
+
+// NewDecoder registers all speed messages, inbound restricts directions as Decoder.Inbound does
+func NewDecoder(inbound messages.Direction) (*messages.Decoder, error) {
+	decoder := messages.NewDecoder()
+	for _, r := range Messages {
+		err := decoder.Register(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register speed messages: %w", err)
+		}
+	}
+	decoder.Inbound(inbound)
+	return decoder, nil
+}
@@ -0,0 +1,20 @@
+package protocol
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDecoder(t *testing.T) {
+	decoder, err := NewDecoder(messages.ClientToServer)
+	require.NoError(t, err)
+
+	regs := decoder.Registrations()
+	require.Len(t, regs, len(Messages))
+	for _, r := range regs {
+		require.NotNil(t, r.Type, r.MsgType)
+		require.NotNil(t, r.Codec, r.Type)
+	}
+}
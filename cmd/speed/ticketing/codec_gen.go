@@ -0,0 +1,82 @@
+// Code generated by codecgen; DO NOT EDIT.
+
+package ticketing
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"reflect"
+)
+
+// TicketMsgCodec encodes and decodes TicketMsg without reflection
+var TicketMsgCodec = messages.Codec{
+	Type: reflect.TypeOf(TicketMsg{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendTicketMsg(b, msg.(TicketMsg))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeTicketMsg(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendTicketMsg(b []byte, m TicketMsg) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	w.String8(m.Plate)
+	w.Uint16(m.Road)
+	w.Uint16(m.Mile1)
+	w.Time32(m.Timestamp1)
+	w.Uint16(m.Mile2)
+	w.Time32(m.Timestamp2)
+	w.Uint16(m.Speed)
+	return w.Bytes(), w.Err()
+}
+
+func decodeTicketMsg(payload []byte) (TicketMsg, int, error) {
+	r := messages.NewWireReader(payload)
+	var m TicketMsg
+	m.Plate = r.String8()
+	m.Road = r.Uint16()
+	m.Mile1 = r.Uint16()
+	m.Timestamp1 = r.Time32()
+	m.Mile2 = r.Uint16()
+	m.Timestamp2 = r.Time32()
+	m.Speed = r.Uint16()
+	if r.Err() != nil {
+		return TicketMsg{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
+
+// IAmDispatcherMsgCodec encodes and decodes IAmDispatcherMsg without reflection
+var IAmDispatcherMsgCodec = messages.Codec{
+	Type: reflect.TypeOf(IAmDispatcherMsg{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendIAmDispatcherMsg(b, msg.(IAmDispatcherMsg))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeIAmDispatcherMsg(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendIAmDispatcherMsg(b []byte, m IAmDispatcherMsg) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	w.Uint16Array8(m.Roads)
+	return w.Bytes(), w.Err()
+}
+
+func decodeIAmDispatcherMsg(payload []byte) (IAmDispatcherMsg, int, error) {
+	r := messages.NewWireReader(payload)
+	var m IAmDispatcherMsg
+	m.Roads = r.Uint16Array8()
+	if r.Err() != nil {
+		return IAmDispatcherMsg{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
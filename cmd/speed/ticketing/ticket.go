@@ -1,5 +1,7 @@
 package ticketing
 
+//go:generate go run max-mulawa/echo/cmd/speed/messages/codecgen -types TicketMsg,IAmDispatcherMsg
+
 import (
 	"max-mulawa/echo/cmd/speed/messages"
 	"time"
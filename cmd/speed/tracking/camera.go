@@ -1,5 +1,7 @@
 package tracking
 
+//go:generate go run max-mulawa/echo/cmd/speed/messages/codecgen -types IAmCameraMsg,MeasurementTimeMsg
+
 import (
 	"max-mulawa/echo/cmd/speed/messages"
 	"time"
@@ -0,0 +1,76 @@
+// Code generated by codecgen; DO NOT EDIT.
+
+package tracking
+
+import (
+	"max-mulawa/echo/cmd/speed/messages"
+	"reflect"
+)
+
+// IAmCameraMsgCodec encodes and decodes IAmCameraMsg without reflection
+var IAmCameraMsgCodec = messages.Codec{
+	Type: reflect.TypeOf(IAmCameraMsg{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendIAmCameraMsg(b, msg.(IAmCameraMsg))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeIAmCameraMsg(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendIAmCameraMsg(b []byte, m IAmCameraMsg) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	w.Uint16(m.Road)
+	w.Uint16(m.Mile)
+	w.Uint16(m.Limit)
+	return w.Bytes(), w.Err()
+}
+
+func decodeIAmCameraMsg(payload []byte) (IAmCameraMsg, int, error) {
+	r := messages.NewWireReader(payload)
+	var m IAmCameraMsg
+	m.Road = r.Uint16()
+	m.Mile = r.Uint16()
+	m.Limit = r.Uint16()
+	if r.Err() != nil {
+		return IAmCameraMsg{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
+
+// MeasurementTimeMsgCodec encodes and decodes MeasurementTimeMsg without reflection
+var MeasurementTimeMsgCodec = messages.Codec{
+	Type: reflect.TypeOf(MeasurementTimeMsg{}),
+	Append: func(b []byte, msg interface{}) ([]byte, error) {
+		return appendMeasurementTimeMsg(b, msg.(MeasurementTimeMsg))
+	},
+	Decode: func(payload []byte) (interface{}, int, error) {
+		m, n, err := decodeMeasurementTimeMsg(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m, n, nil
+	},
+}
+
+func appendMeasurementTimeMsg(b []byte, m MeasurementTimeMsg) ([]byte, error) {
+	w := messages.NewWireWriter(b)
+	w.String8(m.Plate)
+	w.Time32(m.Timestamp)
+	return w.Bytes(), w.Err()
+}
+
+func decodeMeasurementTimeMsg(payload []byte) (MeasurementTimeMsg, int, error) {
+	r := messages.NewWireReader(payload)
+	var m MeasurementTimeMsg
+	m.Plate = r.String8()
+	m.Timestamp = r.Time32()
+	if r.Err() != nil {
+		return MeasurementTimeMsg{}, 0, r.Err()
+	}
+	return m, r.Offset(), nil
+}
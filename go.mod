@@ -2,7 +2,10 @@ module max-mulawa/echo
 
 go 1.18
 
-require github.com/stretchr/testify v1.8.0
+require (
+	github.com/stretchr/testify v1.8.0
+	golang.org/x/net v0.2.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect